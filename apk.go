@@ -0,0 +1,204 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Alpine apk packages written in Go: the gzip streams of the control
+// tar (.PKGINFO and scripts) and the data tar concatenated, no abuild
+// needed. The packages are not signed, install them with
+// apk add --allow-untrusted or sign them with abuild-sign.
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// APKConfig has the apk specific settings of the packages section
+type APKConfig struct {
+	Depends []string `json:"depends"` // e.g. ["musl>=1.2"], default packages depends
+}
+
+// Alpine architectures of GOARCH
+var apkArchs = map[string]string{
+	"386":     "x86",
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"loong64": "loongarch64",
+	"ppc64le": "ppc64le",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// Scripts of an apk, the install scripts also run on upgrade like the
+// deb and rpm ones
+var apkScripts = map[string][]string{
+	"preinstall":  {".pre-install", ".pre-upgrade"},
+	"postinstall": {".post-install", ".post-upgrade"},
+	"preremove":   {".pre-deinstall"},
+	"postremove":  {".post-deinstall"},
+}
+
+// Alpine architecture of a platform, arm is armv7 for GOARM 7 (the
+// default) and armhf for GOARM 6. Alpine has no soft float arm.
+func apkArch(p platform) (string, bool) {
+	if p.GOARCH == "arm" {
+		switch p.GOARM {
+		case "5":
+			return "", false
+		case "6":
+			return "armhf", true
+		}
+		return "armv7", true
+	}
+	arch, ok := apkArchs[p.GOARCH]
+	return arch, ok
+}
+
+// Alpine version: pre-releases use "_" (v1.2.0-rc1 becomes 1.2.0_rc1,
+// which sorts before 1.2.0)
+func apkVersion(version string) string {
+	v := strings.TrimPrefix(version, "v")
+	return strings.ReplaceAll(v, "-", "_")
+}
+
+// Dependency in apk and pacman syntax ("name>=version") from one like
+// "musl>=1.2" or Debian style "libc6 (>= 2.31)"
+func packageDependency(dep string) (string, error) {
+	m := rpmDependency.FindStringSubmatch(strings.TrimSpace(dep))
+	if m == nil || (m[2] == "") != (m[3] == "") {
+		return "", fmt.Errorf("invalid dependency %q", dep)
+	}
+	op := strings.NewReplacer("<<", "<", ">>", ">").Replace(m[2])
+	return m[1] + op + m[3], nil
+}
+
+// Build an apk: control and data gzip streams
+func buildAPK(c *packageContent, arch string) ([]byte, error) {
+	data, err := apkDataTar(c)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	pkginfo, err := apkPKGINFO(c, arch, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []packageFile{{Dst: ".PKGINFO", Mode: 0644, Data: []byte(pkginfo)}}
+	for _, kind := range []string{"preinstall", "postinstall", "preremove", "postremove"} {
+		if script, ok := c.Scripts[kind]; ok {
+			for _, name := range apkScripts[kind] {
+				files = append(files, packageFile{Dst: name, Mode: 0755, Data: script})
+			}
+		}
+	}
+	// the control tar has no end of archive blocks, the data tar follows
+	var control bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&control, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		if err := tw.WriteHeader(rootTarHeader(f.Dst, tar.TypeReg, f.Mode, int64(len(f.Data)))); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return append(control.Bytes(), data...), nil
+}
+
+// The .PKGINFO file
+func apkPKGINFO(c *packageContent, arch, datahash string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s\n", me, versionString())
+	fmt.Fprintf(&b, "pkgname = %s\n", c.Name)
+	fmt.Fprintf(&b, "pkgver = %s-r%s\n", apkVersion(c.Upstream), c.Release)
+	fmt.Fprintf(&b, "pkgdesc = %s\n", c.summary())
+	if c.Config.Homepage != "" {
+		fmt.Fprintf(&b, "url = %s\n", c.Config.Homepage)
+	}
+	fmt.Fprintf(&b, "builddate = %d\n", buildStart.Unix())
+	if c.Config.Maintainer != "" {
+		fmt.Fprintf(&b, "packager = %s\n", c.Config.Maintainer)
+		fmt.Fprintf(&b, "maintainer = %s\n", c.Config.Maintainer)
+	}
+	fmt.Fprintf(&b, "size = %d\n", c.size())
+	fmt.Fprintf(&b, "arch = %s\n", arch)
+	fmt.Fprintf(&b, "origin = %s\n", c.Name)
+	if c.Config.License != "" {
+		fmt.Fprintf(&b, "license = %s\n", c.Config.License)
+	}
+	depends := c.Config.Depends
+	if c.Config.APK != nil && len(c.Config.APK.Depends) > 0 {
+		depends = c.Config.APK.Depends
+	}
+	for _, dep := range depends {
+		d, err := packageDependency(dep)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "depend = %s\n", d)
+	}
+	fmt.Fprintf(&b, "datahash = %s\n", datahash)
+	return b.String(), nil
+}
+
+// The data tar.gz, every file has its sha1 in a PAX record as apk expects
+func apkDataTar(c *packageContent) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+	for _, dir := range c.dirs() {
+		if err := tw.WriteHeader(rootTarHeader(strings.TrimPrefix(dir, "/")+"/", tar.TypeDir, 0755, 0)); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range c.Files {
+		h := rootTarHeader(strings.TrimPrefix(f.Dst, "/"), tar.TypeReg, f.Mode, int64(len(f.Data)))
+		sum := sha1.Sum(f.Data)
+		h.PAXRecords = map[string]string{"APK-TOOLS.checksum.SHA1": hex.EncodeToString(sum[:])}
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Tar header of an entry owned by root
+func rootTarHeader(name string, typeflag byte, mode, size int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Mode:     mode,
+		Size:     size,
+		ModTime:  buildStart,
+		Uname:    "root",
+		Gname:    "root",
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Arch Linux packages written in Go: a tar of .PKGINFO, .MTREE,
+// .INSTALL and the files compressed with pkg/zstd, no makepkg needed
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/muquit/go-xbuild-go/pkg/zstd"
+)
+
+// ArchLinuxConfig has the Arch Linux specific settings of the packages
+// section
+type ArchLinuxConfig struct {
+	Depends []string `json:"depends"` // e.g. ["glibc>=2.31"], default packages depends
+}
+
+// Arch Linux (and Arch Linux ARM) architectures of GOARCH
+var archLinuxArchs = map[string]string{
+	"386":     "i686",
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"loong64": "loong64",
+	"ppc64le": "powerpc64le",
+	"riscv64": "riscv64",
+}
+
+// Functions of the .INSTALL script, the install scripts also run on
+// upgrade like the deb and rpm ones
+var archLinuxScripts = map[string][]string{
+	"preinstall":  {"pre_install", "pre_upgrade"},
+	"postinstall": {"post_install", "post_upgrade"},
+	"preremove":   {"pre_remove"},
+	"postremove":  {"post_remove"},
+}
+
+// Arch Linux architecture of a platform, arm is armv7h for GOARM 7 (the
+// default), armv6h and arm for GOARM 6 and 5
+func archLinuxArch(p platform) (string, bool) {
+	if p.GOARCH == "arm" {
+		switch p.GOARM {
+		case "5":
+			return "arm", true
+		case "6":
+			return "armv6h", true
+		}
+		return "armv7h", true
+	}
+	arch, ok := archLinuxArchs[p.GOARCH]
+	return arch, ok
+}
+
+// Arch Linux version: "-" is not allowed, pre-releases drop it
+// (v1.2.0-rc1 becomes 1.2.0rc1, which sorts before 1.2.0)
+func archLinuxVersion(version string) string {
+	v := strings.TrimPrefix(version, "v")
+	return strings.ReplaceAll(v, "-", "")
+}
+
+// Build a .pkg.tar.zst
+func buildArchLinux(c *packageContent, arch string) ([]byte, error) {
+	pkginfo, err := archLinuxPKGINFO(c, arch)
+	if err != nil {
+		return nil, err
+	}
+	meta := []packageFile{{Dst: ".PKGINFO", Mode: 0644, Data: []byte(pkginfo)}}
+	if install := archLinuxInstall(c); install != "" {
+		meta = append(meta, packageFile{Dst: ".INSTALL", Mode: 0644, Data: []byte(install)})
+	}
+	mtree, err := archLinuxMTREE(c, meta)
+	if err != nil {
+		return nil, err
+	}
+	meta = append(meta[:1], append([]packageFile{{Dst: ".MTREE", Mode: 0644, Data: mtree}}, meta[1:]...)...)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(h *tar.Header, data []byte) error {
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	for _, f := range meta {
+		if err := write(rootTarHeader(f.Dst, tar.TypeReg, f.Mode, int64(len(f.Data))), f.Data); err != nil {
+			return nil, err
+		}
+	}
+	// directories before the files in them
+	entries := archLinuxEntries(c)
+	for _, e := range entries {
+		var err error
+		if e.dir {
+			err = write(rootTarHeader(e.name+"/", tar.TypeDir, 0755, 0), nil)
+		} else {
+			err = write(rootTarHeader(e.name, tar.TypeReg, e.file.Mode, int64(len(e.file.Data))), e.file.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return zstd.Encode(buf.Bytes()), nil
+}
+
+// archLinuxEntry is a directory or file of the package, relative to /
+type archLinuxEntry struct {
+	name string
+	dir  bool
+	file packageFile
+}
+
+// Directories and files of the package sorted by path
+func archLinuxEntries(c *packageContent) []archLinuxEntry {
+	var entries []archLinuxEntry
+	for _, dir := range c.dirs() {
+		entries = append(entries, archLinuxEntry{name: strings.TrimPrefix(dir, "/"), dir: true})
+	}
+	for _, f := range c.Files {
+		entries = append(entries, archLinuxEntry{name: strings.TrimPrefix(f.Dst, "/"), file: f})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries
+}
+
+// The .PKGINFO file
+func archLinuxPKGINFO(c *packageContent, arch string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s\n", me, versionString())
+	fmt.Fprintf(&b, "pkgname = %s\n", c.Name)
+	fmt.Fprintf(&b, "pkgbase = %s\n", c.Name)
+	fmt.Fprintf(&b, "xdata = pkgtype=pkg\n")
+	fmt.Fprintf(&b, "pkgver = %s-%s\n", archLinuxVersion(c.Upstream), c.Release)
+	fmt.Fprintf(&b, "pkgdesc = %s\n", c.summary())
+	if c.Config.Homepage != "" {
+		fmt.Fprintf(&b, "url = %s\n", c.Config.Homepage)
+	}
+	fmt.Fprintf(&b, "builddate = %d\n", buildStart.Unix())
+	packager := c.Config.Maintainer
+	if packager == "" {
+		packager = "Unknown Packager"
+	}
+	fmt.Fprintf(&b, "packager = %s\n", packager)
+	fmt.Fprintf(&b, "size = %d\n", c.size())
+	fmt.Fprintf(&b, "arch = %s\n", arch)
+	if c.Config.License != "" {
+		fmt.Fprintf(&b, "license = %s\n", c.Config.License)
+	}
+	for _, f := range c.Files {
+		if f.Config {
+			fmt.Fprintf(&b, "backup = %s\n", strings.TrimPrefix(f.Dst, "/"))
+		}
+	}
+	depends := c.Config.Depends
+	if c.Config.ArchLinux != nil && len(c.Config.ArchLinux.Depends) > 0 {
+		depends = c.Config.ArchLinux.Depends
+	}
+	for _, dep := range depends {
+		d, err := packageDependency(dep)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "depend = %s\n", d)
+	}
+	return b.String(), nil
+}
+
+// The .INSTALL script, each maintainer script runs with /bin/sh in its
+// function. Empty if there are no scripts.
+func archLinuxInstall(c *packageContent) string {
+	var b strings.Builder
+	for _, kind := range []string{"preinstall", "postinstall", "preremove", "postremove"} {
+		script, ok := c.Scripts[kind]
+		if !ok {
+			continue
+		}
+		for _, fn := range archLinuxScripts[kind] {
+			fmt.Fprintf(&b, "%s() {\n/bin/sh <<'XBUILD_SCRIPT_EOF'\n%s", fn, script)
+			if !bytes.HasSuffix(script, []byte("\n")) {
+				b.WriteString("\n")
+			}
+			b.WriteString("XBUILD_SCRIPT_EOF\n}\n\n")
+		}
+	}
+	return b.String()
+}
+
+// The gzipped .MTREE file of the metadata files and the package content,
+// as makepkg writes it with bsdtar
+func archLinuxMTREE(c *packageContent, meta []packageFile) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("#mtree\n/set type=file uid=0 gid=0 mode=644\n")
+	t := fmt.Sprintf("time=%d.0", buildStart.Unix())
+	file := func(name string, f packageFile) {
+		md5sum := md5.Sum(f.Data)
+		sha256sum := sha256.Sum256(f.Data)
+		fmt.Fprintf(&b, "./%s %s", mtreeEscape(name), t)
+		if f.Mode != 0644 {
+			fmt.Fprintf(&b, " mode=%o", f.Mode)
+		}
+		fmt.Fprintf(&b, " size=%d md5digest=%s sha256digest=%s\n", len(f.Data), hex.EncodeToString(md5sum[:]), hex.EncodeToString(sha256sum[:]))
+	}
+	for _, f := range meta {
+		file(f.Dst, f)
+	}
+	for _, e := range archLinuxEntries(c) {
+		if e.dir {
+			fmt.Fprintf(&b, "./%s %s mode=755 type=dir\n", mtreeEscape(e.name), t)
+			continue
+		}
+		file(e.name, e.file)
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write([]byte(b.String()))
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Escape a path for mtree: spaces and other special characters in octal
+func mtreeEscape(name string) string {
+	var b strings.Builder
+	for _, r := range []byte(path.Clean(name)) {
+		if r <= ' ' || r >= 0x7f || r == '\\' || r == '#' || r == '=' {
+			fmt.Fprintf(&b, "\\%03o", r)
+			continue
+		}
+		b.WriteByte(r)
+	}
+	return b.String()
+}
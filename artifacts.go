@@ -0,0 +1,118 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// artifacts.json in the bin directory lists what the build produced:
+// binary and archive of every target/platform with their sizes
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// File in the bin directory listing the artifacts of the last build
+const artifactsFile = "artifacts.json"
+
+// artifact is the result of building one target for one platform
+type artifact struct {
+	Target       string   `json:"target"`   // output name of the target
+	Platform     string   `json:"platform"` // e.g. linux-amd64, raspberry-pi
+	GOOS         string   `json:"goos"`
+	GOARCH       string   `json:"goarch"`
+	GOARM        string   `json:"goarm,omitempty"`
+	Binary       string   `json:"binary"`
+	BinarySize   int64    `json:"binary_size"`
+	Archive      string   `json:"archive"`
+	ArchiveSize  int64    `json:"archive_size"`
+	SBOMs        []string `json:"sboms,omitempty"`
+	Packages     []string `json:"packages,omitempty"`      // deb and other linux packages
+	BuildCommand []string `json:"build_command,omitempty"` // go build command line
+	BuildEnv     []string `json:"build_env,omitempty"`     // environment set for go build
+}
+
+// Key identifying the same artifact across builds
+func (a artifact) key() string {
+	return a.Target + "/" + a.Platform
+}
+
+// Files in the bin directory: archive, SBOMs and packages
+func (a artifact) files() []string {
+	files := append([]string{a.Archive}, a.SBOMs...)
+	return append(files, a.Packages...)
+}
+
+// artifactList is the content of artifacts.json
+type artifactList struct {
+	Project   string     `json:"project"`
+	Version   string     `json:"version"`
+	Artifacts []artifact `json:"artifacts"`
+}
+
+// Record an artifact
+func (l *artifactList) add(a artifact) {
+	if l == nil {
+		return
+	}
+	l.Artifacts = append(l.Artifacts, a)
+}
+
+// Remove an artifact by key
+func (l *artifactList) remove(key string) {
+	if l == nil {
+		return
+	}
+	artifacts := l.Artifacts[:0]
+	for _, a := range l.Artifacts {
+		if a.key() != key {
+			artifacts = append(artifacts, a)
+		}
+	}
+	l.Artifacts = artifacts
+}
+
+// Find an artifact by key
+func (l *artifactList) find(key string) (artifact, bool) {
+	if l != nil {
+		for _, a := range l.Artifacts {
+			if a.key() == key {
+				return a, true
+			}
+		}
+	}
+	return artifact{}, false
+}
+
+// Read artifacts.json from the bin directory, nil if there is none
+func loadArtifacts(binDir string) (*artifactList, error) {
+	data, err := os.ReadFile(filepath.Join(binDir, artifactsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", artifactsFile, err)
+	}
+	var l artifactList
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", artifactsFile, err)
+	}
+	return &l, nil
+}
+
+// Write artifacts.json to the bin directory
+func saveArtifacts(config *Config, version string) error {
+	if config.DryRun || config.Artifacts == nil {
+		return nil
+	}
+	config.Artifacts.Version = version
+	data, err := json.MarshalIndent(config.Artifacts, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(config.BinDir, artifactsFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
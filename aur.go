@@ -0,0 +1,231 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// AUR -bin package of a target: PKGBUILD and .SRCINFO installing the
+// prebuilt linux archives of the release
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// AURConfig configures the AUR package of a target. The project level
+// section is the default for all targets, a target's section replaces it.
+type AURConfig struct {
+	Name          string   `json:"name"`           // package name, default <output name>-bin
+	Description   string   `json:"description"`    // default packages summary
+	Homepage      string   `json:"homepage"`       // default packages homepage or the origin repository
+	License       string   `json:"license"`        // default packages license
+	Maintainer    string   `json:"maintainer"`     // default packages maintainer
+	Release       string   `json:"release"`        // pkgrel, default "1"
+	Depends       []string `json:"depends"`        // default archlinux depends of the packages section
+	Repo          string   `json:"repo"`           // local clone of the AUR repository of the package (optional)
+	CommitMessage string   `json:"commit_message"` // templated, default "{{.Target}} {{.Version}}"
+	Skip          bool     `json:"skip"`           // no package for this target
+}
+
+// aurSource is the archive of one architecture
+type aurSource struct {
+	arch   string
+	url    string
+	sha256 string
+	dir    string // top directory of the archive
+	binary string
+}
+
+// Write the PKGBUILD and .SRCINFO of the targets and commit them to the
+// AUR repository
+func writeAUR(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	for _, target := range projectConfig.Targets {
+		ac := projectConfig.AUR
+		if target.AUR != nil {
+			ac = target.AUR
+		}
+		if ac == nil || ac.Skip {
+			continue
+		}
+		name := ac.Name
+		if name == "" {
+			name = targetOutputName(target) + "-bin"
+		}
+		if config.DryRun {
+			fmt.Printf("Would write %s.PKGBUILD and %s.SRCINFO\n", name, name)
+			continue
+		}
+		pkgbuild, srcinfo, err := aurPackage(ctx, config, version, target, ac, name)
+		if err != nil {
+			return fmt.Errorf("target %s: aur: %v", target.Name, err)
+		}
+		if pkgbuild == "" {
+			fmt.Printf("No linux archives of %s, skipping AUR package\n", target.Name)
+			continue
+		}
+		files := map[string]string{
+			"PKGBUILD": name + ".PKGBUILD",
+			".SRCINFO": name + ".SRCINFO",
+		}
+		if err := writeManifest(config, "aur", files["PKGBUILD"], pkgbuild); err != nil {
+			return err
+		}
+		if err := writeManifest(config, "aur", files[".SRCINFO"], srcinfo); err != nil {
+			return err
+		}
+
+		if ac.Repo == "" {
+			continue
+		}
+		for rel, file := range files {
+			files[rel] = filepath.Join(config.BinDir, file)
+		}
+		if err := commitManifests(ctx, config, version, name, ac.Repo, ac.CommitMessage, files); err != nil {
+			return fmt.Errorf("target %s: aur: %v", target.Name, err)
+		}
+	}
+	return nil
+}
+
+// Render the PKGBUILD and .SRCINFO of a target, empty if the target has
+// no linux archives
+func aurPackage(ctx context.Context, config *Config, version string, target BuildTarget, ac *AURConfig, name string) (string, string, error) {
+	info := newManifestInfo(ctx, config, target, name, ac.Description, ac.Homepage, ac.License)
+	pc := mergePackagesConfig(config.ProjectConfig.Packages, target.Packages)
+	if pc == nil {
+		pc = &PackagesConfig{}
+	}
+	maintainer := ac.Maintainer
+	if maintainer == "" {
+		maintainer = pc.Maintainer
+	}
+	release := ac.Release
+	if release == "" {
+		release = "1"
+	}
+	depends := ac.Depends
+	if len(depends) == 0 && pc.ArchLinux != nil {
+		depends = pc.ArchLinux.Depends
+	}
+	depends = append([]string(nil), depends...)
+	for i, dep := range depends {
+		d, err := packageDependency(dep)
+		if err != nil {
+			return "", "", err
+		}
+		depends[i] = d
+	}
+	program := targetOutputName(target)
+
+	var sources []aurSource
+	for _, a := range targetArtifacts(config, program) {
+		if a.GOOS != "linux" {
+			continue
+		}
+		arch, ok := archLinuxArch(platform{GOOS: a.GOOS, GOARCH: a.GOARCH, GOARM: a.GOARM})
+		if !ok || aurHasArch(sources, arch) {
+			continue
+		}
+		url, err := releaseURL(ctx, config, version, a, a.Archive)
+		if err != nil {
+			return "", "", err
+		}
+		sum, err := releaseSHA256(config.BinDir, a.Archive)
+		if err != nil {
+			return "", "", err
+		}
+		dir := strings.TrimSuffix(a.Archive, ".tar.gz")
+		sources = append(sources, aurSource{arch: arch, url: url, sha256: sum, dir: dir, binary: a.Binary})
+	}
+	if len(sources) == 0 {
+		return "", "", nil
+	}
+
+	quote := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = shellQuote(v)
+		}
+		return strings.Join(quoted, " ")
+	}
+	var archs []string
+	for _, s := range sources {
+		archs = append(archs, s.arch)
+	}
+
+	var b strings.Builder
+	if maintainer != "" {
+		fmt.Fprintf(&b, "# Maintainer: %s\n", maintainer)
+	}
+	fmt.Fprintf(&b, "# Generated by %s %s\n\n", me, versionString())
+	fmt.Fprintf(&b, "pkgname=%s\n", name)
+	fmt.Fprintf(&b, "pkgver=%s\n", archLinuxVersion(version))
+	fmt.Fprintf(&b, "pkgrel=%s\n", release)
+	fmt.Fprintf(&b, "pkgdesc=%s\n", shellQuote(info.Description))
+	fmt.Fprintf(&b, "arch=(%s)\n", quote(archs))
+	if info.Homepage != "" {
+		fmt.Fprintf(&b, "url=%s\n", shellQuote(info.Homepage))
+	}
+	if info.License != "" {
+		fmt.Fprintf(&b, "license=(%s)\n", shellQuote(info.License))
+	}
+	fmt.Fprintf(&b, "provides=(%s)\n", shellQuote(program))
+	fmt.Fprintf(&b, "conflicts=(%s)\n", shellQuote(program))
+	if len(depends) > 0 {
+		fmt.Fprintf(&b, "depends=(%s)\n", quote(depends))
+	}
+	b.WriteString("options=('!strip')\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "source_%s=(%s)\n", s.arch, shellQuote(s.url))
+		fmt.Fprintf(&b, "sha256sums_%s=(%s)\n", s.arch, shellQuote(s.sha256))
+	}
+	b.WriteString("\npackage() {\n  case \"${CARCH}\" in\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "    %s) _binary=%s ;;\n", s.arch, shellQuote(s.dir+"/"+s.binary))
+	}
+	b.WriteString("  esac\n")
+	fmt.Fprintf(&b, "  install -Dm755 \"${srcdir}/${_binary}\" \"${pkgdir}/usr/bin/%s\"\n}\n", program)
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "pkgbase = %s\n", name)
+	fmt.Fprintf(&s, "\tpkgdesc = %s\n", info.Description)
+	fmt.Fprintf(&s, "\tpkgver = %s\n", archLinuxVersion(version))
+	fmt.Fprintf(&s, "\tpkgrel = %s\n", release)
+	if info.Homepage != "" {
+		fmt.Fprintf(&s, "\turl = %s\n", info.Homepage)
+	}
+	for _, arch := range archs {
+		fmt.Fprintf(&s, "\tarch = %s\n", arch)
+	}
+	if info.License != "" {
+		fmt.Fprintf(&s, "\tlicense = %s\n", info.License)
+	}
+	for _, dep := range depends {
+		fmt.Fprintf(&s, "\tdepends = %s\n", dep)
+	}
+	fmt.Fprintf(&s, "\tprovides = %s\n", program)
+	fmt.Fprintf(&s, "\tconflicts = %s\n", program)
+	fmt.Fprintf(&s, "\toptions = !strip\n")
+	for _, src := range sources {
+		fmt.Fprintf(&s, "\tsource_%s = %s\n", src.arch, src.url)
+		fmt.Fprintf(&s, "\tsha256sums_%s = %s\n", src.arch, src.sha256)
+	}
+	fmt.Fprintf(&s, "\npkgname = %s\n", name)
+	return b.String(), s.String(), nil
+}
+
+// Whether an architecture has a source already
+func aurHasArch(sources []aurSource, arch string) bool {
+	for _, s := range sources {
+		if s.arch == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// Single quote a string for the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,240 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Optional gate before building: go vet for every platform (catches
+// platform specific compile errors in build-tagged and _test.go files)
+// and go test for the host platform. The result is saved in the bin
+// directory, -release refuses to publish if the checks failed.
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// File in the bin directory with the result of the checks
+const checksFile = "xbuild-checks.json"
+
+// ChecksConfig configures go vet/go test before building
+type ChecksConfig struct {
+	Vet       bool   `json:"vet"`        // go vet for every platform
+	Test      bool   `json:"test"`       // go test for the host platform
+	Packages  string `json:"packages"`   // packages to check (default: ./...)
+	VetFlags  string `json:"vet_flags"`  // extra go vet flags (optional)
+	TestFlags string `json:"test_flags"` // extra go test flags, e.g. "-race" (optional)
+}
+
+// checksResult is saved in the bin directory after the checks ran
+type checksResult struct {
+	Version string        `json:"version"`
+	Passed  bool          `json:"passed"`
+	Results []checkResult `json:"results"`
+}
+
+type checkResult struct {
+	Check    string `json:"check"` // vet or test
+	Platform string `json:"platform"`
+	Target   string `json:"target,omitempty"` // target whose env vet ran with
+	Passed   bool   `json:"passed"`
+}
+
+// Run the checks enabled in the config before building. The result of
+// an earlier build is removed first, a build without checks leaves none.
+func runChecks(ctx context.Context, config *Config, version string) error {
+	if !config.DryRun {
+		path := filepath.Join(config.BinDir, checksFile)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+	}
+	checks := config.Checks
+	if checks == nil || (!checks.Vet && !checks.Test) {
+		return nil
+	}
+
+	packages := []string{"./..."}
+	if checks.Packages != "" {
+		var err error
+		if packages, err = parseArguments(checks.Packages); err != nil {
+			return fmt.Errorf("failed to parse check packages: %v", err)
+		}
+	}
+
+	result := checksResult{Version: version, Passed: true}
+	record := func(check string, p platform, target string, err error) {
+		result.Results = append(result.Results, checkResult{Check: check, Platform: p.GOOS + "/" + p.GOARCH, Target: target, Passed: err == nil})
+		name := p.GOOS + "/" + p.GOARCH
+		if target != "" {
+			name += " (" + target + ")"
+		}
+		config.Summary.addResult(check, name, err)
+		if err != nil {
+			result.Passed = false
+			fmt.Fprintf(os.Stderr, "%s failed for %s: %v\n", check, name, err)
+		}
+	}
+
+	if checks.Vet {
+		platforms, err := checkPlatforms(config)
+		if err != nil {
+			return err
+		}
+		// vet with the env of every target, targets building with the
+		// same env are vetted once
+		configs := []*Config{config}
+		if config.ProjectConfig != nil && len(config.ProjectConfig.Targets) > 0 {
+			configs = nil
+			for _, target := range config.ProjectConfig.Targets {
+				configs = append(configs, newTargetConfig(config, target))
+			}
+		}
+		vetted := make(map[string]bool)
+		for _, p := range platforms {
+			for _, c := range configs {
+				env, err := buildEnv(c, p, newTemplateData(ctx, c, version, p))
+				if err != nil {
+					return fmt.Errorf("%s/%s: %v", p.GOOS, p.GOARCH, err)
+				}
+				key := strings.Join(env, "\x00")
+				if vetted[key] {
+					continue
+				}
+				vetted[key] = true
+				target := ""
+				if c.Target != nil && len(configs) > 1 {
+					target = c.Target.Name
+					fmt.Printf("\n> Vetting for %s/%s with the env of target %s\n", p.GOOS, p.GOARCH, target)
+				} else {
+					fmt.Printf("\n> Vetting for %s/%s\n", p.GOOS, p.GOARCH)
+				}
+				err = runCheck(ctx, c, "vet", checks.VetFlags, packages, env)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				record("vet", p, target, err)
+			}
+		}
+	}
+
+	if checks.Test {
+		host := platform{
+			GOOS:   runtime.GOOS,
+			GOARCH: runtime.GOARCH,
+			Name:   runtime.GOOS + "-" + runtime.GOARCH,
+			Label:  runtime.GOOS + "/" + runtime.GOARCH,
+		}
+		fmt.Printf("\n> Testing for %s/%s\n", host.GOOS, host.GOARCH)
+		// the tests run with the env of a build for the host
+		env, err := buildEnv(config, host, newTemplateData(ctx, config, version, host))
+		if err != nil {
+			return fmt.Errorf("%s/%s: %v", host.GOOS, host.GOARCH, err)
+		}
+		err = runCheck(ctx, config, "test", checks.TestFlags, packages, env)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		record("test", host, "", err)
+	}
+
+	if config.DryRun {
+		return nil
+	}
+
+	if err := saveChecksResult(config, result); err != nil {
+		return err
+	}
+	if !result.Passed {
+		return fmt.Errorf("checks failed, nothing was built")
+	}
+	return nil
+}
+
+// Get the platforms to vet, every GOOS/GOARCH only once
+func checkPlatforms(config *Config) ([]platform, error) {
+	platforms, err := readPlatforms(config)
+	if err != nil {
+		return nil, err
+	}
+	if buildForPi {
+		platforms = append(platforms, piPlatform("", "7"))
+	}
+
+	seen := make(map[string]bool)
+	var unique []platform
+	for _, p := range platforms {
+		key := p.GOOS + "/" + p.GOARCH
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, p)
+	}
+	return unique, nil
+}
+
+// Run go vet or go test
+func runCheck(ctx context.Context, config *Config, check, flags string, packages, env []string) error {
+	args := []string{check}
+	if flags != "" {
+		flagArgs, err := parseArguments(flags)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s flags: %v", check, err)
+		}
+		args = append(args, flagArgs...)
+	}
+	args = append(args, packages...)
+
+	if config.DryRun {
+		fmt.Printf("  command: go %s\n", quoteArgs(args))
+		for _, e := range env {
+			fmt.Printf("    %s\n", e)
+		}
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Save the result of the checks in the bin directory
+func saveChecksResult(config *Config, result checksResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(config.BinDir, checksFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// Make sure the checks of the build being released did not fail. A bin
+// directory built without checks can be released.
+func checkChecksPassed(config *Config, version string) error {
+	data, err := os.ReadFile(filepath.Join(config.BinDir, checksFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", checksFile, err)
+	}
+
+	var result checksResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", checksFile, err)
+	}
+	if result.Version == version && !result.Passed {
+		return fmt.Errorf("checks failed for %s (see %s), not publishing the release", version, filepath.Join(config.BinDir, checksFile))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Checksums of archives, SBOMs and packages: one combined file per
+// algorithm (sha256sum format) or a sidecar file next to every file,
+// optionally a JSON file with all algorithms
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/muquit/go-xbuild-go/pkg/blake2b"
+)
+
+// Supported checksum algorithms in the order they are written
+var checksumAlgorithms = []string{"sha256", "sha512", "sha1", "blake2b"}
+
+// Hash functions of the checksum algorithms, blake2b is BLAKE2b-512
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"sha1":    sha1.New,
+	"blake2b": blake2b.New512,
+}
+
+// ChecksumsConfig configures the checksum files
+type ChecksumsConfig struct {
+	Algorithms []string `json:"algorithms"` // sha256 (default), sha512, sha1, blake2b
+	Sidecar    bool     `json:"sidecar"`    // <file>.<algorithm> next to every file instead of combined files
+	JSON       bool     `json:"json"`       // <project>-<version>-checksums.json with all algorithms
+	Project    bool     `json:"project"`    // one file for all targets instead of one per target
+}
+
+// checksumsJSON is the content of the JSON checksums file
+type checksumsJSON struct {
+	Project string          `json:"project"`
+	Version string          `json:"version"`
+	Files   []checksumEntry `json:"files"`
+}
+
+type checksumEntry struct {
+	Name      string            `json:"name"`
+	Size      int64             `json:"size"`
+	Checksums map[string]string `json:"checksums"` // algorithm: hex digest
+}
+
+// Check the checksums section of the config file
+func checkChecksumsConfig(c *ChecksumsConfig) error {
+	if c == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, algorithm := range c.Algorithms {
+		if checksumHashes[algorithm] == nil {
+			return fmt.Errorf("checksums: unknown algorithm %q (use %s)", algorithm, strings.Join(checksumAlgorithms, ", "))
+		}
+		if seen[algorithm] {
+			return fmt.Errorf("checksums: algorithm %s is listed twice", algorithm)
+		}
+		seen[algorithm] = true
+	}
+	if c.Project && c.Sidecar {
+		return fmt.Errorf("checksums: project and sidecar can not be used together")
+	}
+	return nil
+}
+
+// Check if one checksums file for the whole project is written at the
+// end of a multi-target build instead of one per target
+func projectChecksums(config *Config) bool {
+	return config.ProjectConfig != nil && config.Checksums != nil && config.Checksums.Project
+}
+
+// Algorithms to write, sha256 if none are configured
+func (c *ChecksumsConfig) algorithms() []string {
+	if c == nil || len(c.Algorithms) == 0 {
+		return []string{"sha256"}
+	}
+	return c.Algorithms
+}
+
+// Name of the combined checksums file of an algorithm. sha256 keeps the
+// historical <project>-<version>-checksums.txt
+func checksumsFileName(config *Config, version, algorithm string) string {
+	if algorithm == "sha256" {
+		return fmt.Sprintf("%s-%s-%s", config.ProjectName, version, config.ChecksumsFile)
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", config.ProjectName, version, algorithm, config.ChecksumsFile)
+}
+
+// Name of the JSON checksums file
+func checksumsJSONName(config *Config, version string) string {
+	return fmt.Sprintf("%s-%s-%s.json", config.ProjectName, version, strings.TrimSuffix(config.ChecksumsFile, ".txt"))
+}
+
+// Combined and JSON checksums files written for a project or target
+func checksumsFiles(config *Config, version string) []string {
+	var files []string
+	if config.Checksums == nil || !config.Checksums.Sidecar {
+		for _, algorithm := range config.Checksums.algorithms() {
+			files = append(files, filepath.Join(config.BinDir, checksumsFileName(config, version, algorithm)))
+		}
+	}
+	if config.Checksums != nil && config.Checksums.JSON {
+		files = append(files, filepath.Join(config.BinDir, checksumsJSONName(config, version)))
+	}
+	return files
+}
+
+// Compute the checksums of a file in one pass without reading it into memory
+func fileChecksums(path string, algorithms []string) (map[string]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		h := checksumHashes[algorithm]()
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sums := make(map[string]string)
+	for algorithm, h := range hashes {
+		sums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, size, nil
+}
+
+// Write the sidecar files of a file, sidecars of algorithms not written
+// are removed so that none is left from a previous build
+func writeSidecars(path string, sums map[string]string) error {
+	for _, algorithm := range checksumAlgorithms {
+		sidecar := path + "." + algorithm
+		sum, ok := sums[algorithm]
+		if !ok {
+			if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+		if err := os.WriteFile(sidecar, []byte(line), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add a file to the JSON checksums file
+func appendChecksumsJSON(config *Config, version string, entry checksumEntry) error {
+	path := filepath.Join(config.BinDir, checksumsJSONName(config, version))
+	list := checksumsJSON{Project: config.ProjectName, Version: version}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", filepath.Base(path), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	list.Files = append(list.Files, entry)
+
+	data, err = json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Remove a file from the checksums files of the target, the sidecars or
+// the lines and JSON entries of the combined files
+func dropChecksums(config *Config, version, name string) error {
+	if projectChecksums(config) {
+		return nil
+	}
+	if err := writeSidecars(filepath.Join(config.BinDir, name), nil); err != nil {
+		return err
+	}
+	for _, algorithm := range config.Checksums.algorithms() {
+		path := filepath.Join(config.BinDir, checksumsFileName(config, version, algorithm))
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var kept strings.Builder
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[1] == name {
+				continue
+			}
+			kept.WriteString(line)
+		}
+		if err := os.WriteFile(path, []byte(kept.String()), 0644); err != nil {
+			return err
+		}
+	}
+	if config.Checksums == nil || !config.Checksums.JSON {
+		return nil
+	}
+	path := filepath.Join(config.BinDir, checksumsJSONName(config, version))
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list checksumsJSON
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", filepath.Base(path), err)
+	}
+	files := list.Files[:0]
+	for _, entry := range list.Files {
+		if entry.Name != name {
+			files = append(files, entry)
+		}
+	}
+	list.Files = files
+	data, err = json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Check if a file is a checksum sidecar file
+func isSidecar(name string) bool {
+	for _, algorithm := range checksumAlgorithms {
+		if strings.HasSuffix(name, "."+algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the project checksums files covering the archives, SBOMs and
+// packages of every target, sorted by name
+func writeProjectChecksums(config *Config, version string) error {
+	if !projectChecksums(config) {
+		return nil
+	}
+	if config.DryRun {
+		for _, file := range checksumsFiles(config, version) {
+			fmt.Printf("Would write %s\n", filepath.Base(file))
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, a := range config.Artifacts.Artifacts {
+		for _, name := range a.files() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	algorithms := config.Checksums.algorithms()
+	lines := make(map[string]*strings.Builder)
+	for _, algorithm := range algorithms {
+		lines[algorithm] = &strings.Builder{}
+	}
+	list := checksumsJSON{Project: config.ProjectName, Version: version}
+	for _, name := range names {
+		sums, size, err := fileChecksums(filepath.Join(config.BinDir, name), algorithms)
+		if err != nil {
+			return fmt.Errorf("failed to take checksum: %v", err)
+		}
+		for _, algorithm := range algorithms {
+			fmt.Fprintf(lines[algorithm], "%s  %s\n", sums[algorithm], name)
+		}
+		list.Files = append(list.Files, checksumEntry{Name: name, Size: size, Checksums: sums})
+	}
+
+	for _, algorithm := range algorithms {
+		checksumFilename := filepath.Join(config.BinDir, checksumsFileName(config, version, algorithm))
+		if err := os.WriteFile(checksumFilename, []byte(lines[algorithm].String()), 0644); err != nil {
+			return fmt.Errorf("failed to write checksum file: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", filepath.Base(checksumFilename))
+	}
+	if config.Checksums.JSON {
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		checksumFilename := filepath.Join(config.BinDir, checksumsJSONName(config, version))
+		if err := os.WriteFile(checksumFilename, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write checksum file: %v", err)
+		}
+		fmt.Printf("Wrote %s\n", filepath.Base(checksumFilename))
+	}
+	return nil
+}
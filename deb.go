@@ -0,0 +1,211 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Debian packages written in Go: an ar archive with debian-binary,
+// control.tar.gz and data.tar.gz, no dpkg-deb needed
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Debian architectures of GOARCH
+var debArchs = map[string]string{
+	"386":      "i386",
+	"amd64":    "amd64",
+	"arm64":    "arm64",
+	"loong64":  "loong64",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64le": "mips64el",
+	"ppc64le":  "ppc64el",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+}
+
+// Debian architecture of a platform, arm is armhf for GOARM 7 (the
+// default) and armel for GOARM 5 and 6
+func debArch(p platform) (string, bool) {
+	if p.GOARCH == "arm" {
+		if p.GOARM == "5" || p.GOARM == "6" {
+			return "armel", true
+		}
+		return "armhf", true
+	}
+	arch, ok := debArchs[p.GOARCH]
+	return arch, ok
+}
+
+// Debian maintainer script names
+var debScripts = map[string]string{
+	"preinstall":  "preinst",
+	"postinstall": "postinst",
+	"preremove":   "prerm",
+	"postremove":  "postrm",
+}
+
+// Build a .deb package
+func buildDeb(c *packageContent, arch string) ([]byte, error) {
+	control, err := debControlTar(c, arch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := debDataTar(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", control},
+		{"data.tar.gz", data},
+	}
+	for _, m := range members {
+		writeArMember(&buf, m.name, m.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// Append a member to an ar archive
+func writeArMember(buf *bytes.Buffer, name string, data []byte) {
+	fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, buildStart.Unix(), 0, 0, 0100644, len(data))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte('\n')
+	}
+}
+
+// The control file
+func debControl(c *packageContent, arch string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Package: %s\n", c.Name)
+	fmt.Fprintf(&b, "Version: %s-%s\n", c.Version, c.Release)
+	fmt.Fprintf(&b, "Architecture: %s\n", arch)
+	fmt.Fprintf(&b, "Maintainer: %s\n", c.Config.Maintainer)
+	fmt.Fprintf(&b, "Installed-Size: %d\n", (c.size()+1023)/1024)
+	if len(c.Config.Depends) > 0 {
+		fmt.Fprintf(&b, "Depends: %s\n", strings.Join(c.Config.Depends, ", "))
+	}
+	section := c.Config.Section
+	if section == "" {
+		section = "utils"
+	}
+	fmt.Fprintf(&b, "Section: %s\n", section)
+	fmt.Fprintf(&b, "Priority: optional\n")
+	if c.Config.Homepage != "" {
+		fmt.Fprintf(&b, "Homepage: %s\n", c.Config.Homepage)
+	}
+	fmt.Fprintf(&b, "Description: %s\n", c.summary())
+	for _, line := range strings.Split(strings.TrimSpace(c.Config.Description), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if c.Config.Description != "" {
+				b.WriteString(" .\n")
+			}
+			continue
+		}
+		fmt.Fprintf(&b, " %s\n", line)
+	}
+	return b.String()
+}
+
+// control.tar.gz: control, md5sums, conffiles and maintainer scripts
+func debControlTar(c *packageContent, arch string) ([]byte, error) {
+	var md5sums, conffiles strings.Builder
+	for _, f := range c.Files {
+		sum := md5.Sum(f.Data)
+		fmt.Fprintf(&md5sums, "%s  %s\n", hex.EncodeToString(sum[:]), strings.TrimPrefix(f.Dst, "/"))
+		if f.Config {
+			fmt.Fprintf(&conffiles, "%s\n", f.Dst)
+		}
+	}
+
+	var files []packageFile
+	files = append(files, packageFile{Dst: "./control", Mode: 0644, Data: []byte(debControl(c, arch))})
+	files = append(files, packageFile{Dst: "./md5sums", Mode: 0644, Data: []byte(md5sums.String())})
+	if conffiles.Len() > 0 {
+		files = append(files, packageFile{Dst: "./conffiles", Mode: 0644, Data: []byte(conffiles.String())})
+	}
+	for _, kind := range []string{"preinstall", "postinstall", "preremove", "postremove"} {
+		if script, ok := c.Scripts[kind]; ok {
+			files = append(files, packageFile{Dst: "./" + debScripts[kind], Mode: 0755, Data: script})
+		}
+	}
+	return tarGz([]string{"./"}, files)
+}
+
+// data.tar.gz: the installed files with their directories
+func debDataTar(c *packageContent) ([]byte, error) {
+	dirs := []string{"./"}
+	for _, dir := range c.dirs() {
+		dirs = append(dirs, "."+dir+"/")
+	}
+	files := make([]packageFile, 0, len(c.Files))
+	for _, f := range c.Files {
+		f.Dst = "." + f.Dst
+		files = append(files, f)
+	}
+	return tarGz(dirs, files)
+}
+
+// Write directories and files owned by root to a tar.gz in memory
+func tarGz(dirs []string, files []packageFile) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePackageTar(zw, dirs, files); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write directories and files owned by root to a tar stream
+func writePackageTar(w io.Writer, dirs []string, files []packageFile) error {
+	tw := tar.NewWriter(w)
+	for _, dir := range dirs {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir,
+			Mode:     0755,
+			ModTime:  buildStart,
+			Uname:    "root",
+			Gname:    "root",
+		}); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.Dst,
+			Mode:     f.Mode,
+			Size:     int64(len(f.Data)),
+			ModTime:  buildStart,
+			Uname:    "root",
+			Gname:    "root",
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
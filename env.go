@@ -0,0 +1,168 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Environment variables for go build from build-config.json
+// Maps can be specified at project and target level, both for all
+// platforms ("env") and for specific platforms ("platform_env")
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Variables set from the platform, can't be overridden from the config
+var platformVars = map[string]bool{
+	"GOOS":   true,
+	"GOARCH": true,
+	"GOARM":  true,
+}
+
+// Validate an env map from the config
+func checkEnv(where string, env map[string]string) error {
+	for k := range env {
+		if k == "" {
+			return fmt.Errorf("%s: empty environment variable name", where)
+		}
+		if platformVars[k] {
+			return fmt.Errorf("%s: %s can not be set in env, it comes from the platform", where, k)
+		}
+	}
+	return nil
+}
+
+// Validate all env maps of the project config
+func checkProjectEnv(config *ProjectConfig) error {
+	if err := checkEnv("env", config.Env); err != nil {
+		return err
+	}
+	for key, env := range config.PlatformEnv {
+		if err := checkEnv("platform_env "+key, env); err != nil {
+			return err
+		}
+	}
+	if err := checkCgoToolchain("project", config.CgoToolchain, config.Zig); err != nil {
+		return err
+	}
+	for _, target := range config.Targets {
+		if err := checkEnv("target "+target.Name+" env", target.Env); err != nil {
+			return err
+		}
+		if err := checkCgoToolchain("target "+target.Name, target.CgoToolchain, target.Zig); err != nil {
+			return err
+		}
+		for key, env := range target.PlatformEnv {
+			if err := checkEnv("target "+target.Name+" platform_env "+key, env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get the env maps of platform_env that apply to the platform, "GOOS/GOARCH"
+// first, then the platform name for Raspberry Pi (e.g. "raspberry-pi-jessie")
+func platformEnv(envs map[string]map[string]string, p platform) []map[string]string {
+	maps := []map[string]string{envs[p.GOOS+"/"+p.GOARCH]}
+	if p.GOARM != "" {
+		maps = append(maps, envs[p.Name])
+	}
+	return maps
+}
+
+// Get the environment variables from the config for the platform. Later
+// maps win: project env, project platform_env, target env, target
+// platform_env. The cgo toolchain variables (CC, CXX, CGO_ENABLED) come
+// right after the maps of the level cgo_toolchain is set at. Values are
+// expanded as templates.
+func configEnv(config *Config, p platform, data templateData) ([]string, error) {
+	var layers []map[string]string
+	pc := config.ProjectConfig
+	t := config.Target
+	gomips := configVar(config, p, "GOMIPS")
+	if pc != nil {
+		layers = append(layers, pc.Env)
+		layers = append(layers, platformEnv(pc.PlatformEnv, p)...)
+		if pc.CgoToolchain == "zig" && (t == nil || t.CgoToolchain == "") {
+			zig := pc.Zig
+			if t != nil && t.Zig != nil {
+				zig = t.Zig
+			}
+			env, err := zigEnv(p, zig, gomips)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, env)
+		}
+	}
+	if t != nil {
+		layers = append(layers, t.Env)
+		layers = append(layers, platformEnv(t.PlatformEnv, p)...)
+		if t.CgoToolchain == "zig" {
+			zig := t.Zig
+			if zig == nil && pc != nil {
+				zig = pc.Zig
+			}
+			env, err := zigEnv(p, zig, gomips)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, env)
+		}
+	}
+
+	vars := make(map[string]string)
+	for _, layer := range layers {
+		for k, v := range layer {
+			vars[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v, err := expandTemplate(vars[k], data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand env %s: %v", k, err)
+		}
+		env = append(env, k+"="+v)
+	}
+	return env, nil
+}
+
+// Get a variable as go build will see it on the platform: from the last
+// env map of the config setting it, else from the inherited environment
+func configVar(config *Config, p platform, name string) string {
+	var maps []map[string]string
+	if pc := config.ProjectConfig; pc != nil {
+		maps = append(maps, pc.Env)
+		maps = append(maps, platformEnv(pc.PlatformEnv, p)...)
+	}
+	if t := config.Target; t != nil {
+		maps = append(maps, t.Env)
+		maps = append(maps, platformEnv(t.PlatformEnv, p)...)
+	}
+	value := os.Getenv(name)
+	for _, m := range maps {
+		if v, ok := m[name]; ok {
+			value = v
+		}
+	}
+	return value
+}
+
+// buildEnv returns the effective environment for go build on the platform,
+// on top of the inherited environment
+func buildEnv(config *Config, p platform, data templateData) ([]string, error) {
+	env, err := configEnv(config, p, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(p.env(), env...), nil
+}
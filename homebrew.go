@@ -0,0 +1,184 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Homebrew formula of a target, installing the darwin and linux
+// archives of the release
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HomebrewConfig configures the Homebrew formula of a target. The project
+// level section is the default for all targets, a target's section
+// replaces it.
+type HomebrewConfig struct {
+	Name          string `json:"name"`           // formula name, default output name of the target
+	Description   string `json:"description"`    // default packages summary
+	Homepage      string `json:"homepage"`       // default packages homepage or the origin repository
+	License       string `json:"license"`        // default packages license
+	Test          string `json:"test"`           // Ruby code of the test block, default runs the smoke_test args
+	Tap           string `json:"tap"`            // local clone of the tap repository (optional)
+	Directory     string `json:"directory"`      // directory of formulas in the tap, default "Formula"
+	CommitMessage string `json:"commit_message"` // templated, default "{{.Target}} {{.Version}}"
+	Skip          bool   `json:"skip"`           // no formula for this target
+}
+
+// Platforms of a formula: Homebrew OS block, CPU condition and GOOS/GOARCH
+var homebrewPlatforms = []struct {
+	os, cpu             string
+	goos, goarch, goarm string
+}{
+	{"on_macos", "Hardware::CPU.intel?", "darwin", "amd64", ""},
+	{"on_macos", "Hardware::CPU.arm?", "darwin", "arm64", ""},
+	{"on_linux", "Hardware::CPU.intel? && Hardware::CPU.is_64_bit?", "linux", "amd64", ""},
+	{"on_linux", "Hardware::CPU.arm? && Hardware::CPU.is_64_bit?", "linux", "arm64", ""},
+	{"on_linux", "Hardware::CPU.arm? && !Hardware::CPU.is_64_bit?", "linux", "arm", "7"},
+}
+
+// Write the formulas of the targets and commit them to the tap
+func writeHomebrew(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	for _, target := range projectConfig.Targets {
+		hc := projectConfig.Homebrew
+		if target.Homebrew != nil {
+			hc = target.Homebrew
+		}
+		if hc == nil || hc.Skip {
+			continue
+		}
+		name := hc.Name
+		if name == "" {
+			name = targetOutputName(target)
+		}
+		file := name + ".rb"
+		if config.DryRun {
+			fmt.Printf("Would write Homebrew formula %s\n", file)
+			continue
+		}
+		formula, err := homebrewFormula(ctx, config, version, target, hc, name)
+		if err != nil {
+			return fmt.Errorf("target %s: homebrew: %v", target.Name, err)
+		}
+		if formula == "" {
+			fmt.Printf("No darwin or linux archives of %s, skipping Homebrew formula\n", target.Name)
+			continue
+		}
+		path := filepath.Join(config.BinDir, file)
+		if err := os.WriteFile(path, []byte(formula), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Created Homebrew formula %s\n", file)
+		config.Summary.add("homebrew", file)
+
+		if hc.Tap == "" {
+			continue
+		}
+		dir := hc.Directory
+		if dir == "" {
+			dir = "Formula"
+		}
+		files := map[string]string{filepath.Join(dir, file): path}
+		if err := commitManifests(ctx, config, version, name, hc.Tap, hc.CommitMessage, files); err != nil {
+			return fmt.Errorf("target %s: homebrew: %v", target.Name, err)
+		}
+	}
+	return nil
+}
+
+// Render the formula, empty if the target has no darwin or linux archives
+func homebrewFormula(ctx context.Context, config *Config, version string, target BuildTarget, hc *HomebrewConfig, name string) (string, error) {
+	info := newManifestInfo(ctx, config, target, name, hc.Description, hc.Homepage, hc.License)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s, do not edit\n", me, versionString())
+	fmt.Fprintf(&b, "class %s < Formula\n", homebrewClass(name))
+	fmt.Fprintf(&b, "  desc %s\n", rubyString(info.Description))
+	if info.Homepage != "" {
+		fmt.Fprintf(&b, "  homepage %s\n", rubyString(info.Homepage))
+	}
+	fmt.Fprintf(&b, "  version %s\n", rubyString(strings.TrimPrefix(version, "v")))
+	if info.License != "" {
+		fmt.Fprintf(&b, "  license %s\n", rubyString(info.License))
+	}
+
+	artifacts := targetArtifacts(config, targetOutputName(target))
+	found := false
+	for _, osBlock := range []string{"on_macos", "on_linux"} {
+		var blocks []string
+		for _, p := range homebrewPlatforms {
+			if p.os != osBlock {
+				continue
+			}
+			a, ok := findArtifact(artifacts, p.goos, p.goarch, p.goarm)
+			if !ok {
+				continue
+			}
+			url, err := releaseURL(ctx, config, version, a, a.Archive)
+			if err != nil {
+				return "", err
+			}
+			sum, err := releaseSHA256(config.BinDir, a.Archive)
+			if err != nil {
+				return "", err
+			}
+			blocks = append(blocks, fmt.Sprintf(
+				"    if %s\n      url %s\n      sha256 %s\n\n      def install\n        bin.install %s => %s\n      end\n    end\n",
+				p.cpu, rubyString(url), rubyString(sum), rubyString(a.Binary), rubyString(name)))
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		found = true
+		fmt.Fprintf(&b, "\n  %s do\n%s  end\n", osBlock, strings.Join(blocks, ""))
+	}
+	if !found {
+		return "", nil
+	}
+
+	test := hc.Test
+	if test == "" {
+		test = fmt.Sprintf("assert_predicate bin/%s, :executable?", rubyString(name))
+		if target.SmokeTest != nil && len(target.SmokeTest.Args) > 0 {
+			args := []string{`"#{bin}/` + strings.TrimPrefix(rubyString(name), `"`)}
+			for _, arg := range target.SmokeTest.Args {
+				args = append(args, rubyString(arg))
+			}
+			test = "system " + strings.Join(args, ", ")
+		}
+	}
+	fmt.Fprintf(&b, "\n  test do\n")
+	for _, line := range strings.Split(strings.TrimRight(test, "\n"), "\n") {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+	fmt.Fprintf(&b, "  end\nend\n")
+	return b.String(), nil
+}
+
+// Class name of a formula: my-tool_2 becomes MyTool2, @ becomes AT
+func homebrewClass(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range strings.ReplaceAll(name, "@", "AT") {
+		if r == '-' || r == '_' || r == '.' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = []rune(strings.ToUpper(string(r)))[0]
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Ruby string literal
+func rubyString(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "#", `\#`).Replace(s)
+	return `"` + s + `"`
+}
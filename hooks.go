@@ -0,0 +1,167 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Pre- and post-build hooks from build-config.json
+//   before/after           - before/after building (project: all
+//                            targets, target: that target)
+//   before_each/after_each - before/after go build of each platform.
+//                            after_each gets the freshly built binary
+//                            before it is copied to the dist directory
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Hooks are commands run around the build. A command is split into
+// arguments like -build-args and is not run through a shell, use
+// "sh -c '...'" for pipes or redirections. {{.Version}}, {{.GOOS}},
+// {{.Binary}} etc. can be used in commands.
+type Hooks struct {
+	Before     []string `json:"before"`
+	After      []string `json:"after"`
+	BeforeEach []string `json:"before_each"`
+	AfterEach  []string `json:"after_each"`
+	OnFailure  string   `json:"on_failure"` // "fail" (default) or "warn"
+	Timeout    string   `json:"timeout"`    // per command, e.g. "2m" (optional)
+}
+
+// Get the commands of a stage
+func (h *Hooks) commands(stage string) []string {
+	if h == nil {
+		return nil
+	}
+	switch stage {
+	case "before":
+		return h.Before
+	case "after":
+		return h.After
+	case "before_each":
+		return h.BeforeEach
+	case "after_each":
+		return h.AfterEach
+	}
+	return nil
+}
+
+// Validate hooks of a project or target
+func checkHooks(where string, h *Hooks) error {
+	if h == nil {
+		return nil
+	}
+	switch h.OnFailure {
+	case "", "fail", "warn":
+	default:
+		return fmt.Errorf("%s: unknown hooks on_failure %q (supported: fail, warn)", where, h.OnFailure)
+	}
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return fmt.Errorf("%s: invalid hooks timeout %q: %v", where, h.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// Environment variables passed to hook commands
+func hookEnv(config *Config, data templateData) []string {
+	return []string{
+		"XBUILD_VERSION=" + data.Version,
+		"XBUILD_TARGET=" + data.Target,
+		"XBUILD_GOOS=" + data.GOOS,
+		"XBUILD_GOARCH=" + data.GOARCH,
+		"XBUILD_GOARM=" + data.GOARM,
+		"XBUILD_BINARY=" + data.Binary,
+		"XBUILD_COMMIT=" + data.Commit,
+		"XBUILD_DATE=" + data.Date,
+		"XBUILD_BIN_DIR=" + config.BinDir,
+	}
+}
+
+// Run the commands of a stage of hooks
+func runHooks(ctx context.Context, config *Config, h *Hooks, stage string, data templateData) error {
+	commands := h.commands(stage)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	for _, command := range commands {
+		expanded, err := expandTemplate(command, data)
+		if err != nil {
+			return fmt.Errorf("failed to expand %s hook %q: %v", stage, command, err)
+		}
+		args, err := parseArguments(expanded)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s hook %q: %v", stage, command, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if config.DryRun {
+			fmt.Printf("  hook %s: %s\n", stage, quoteArgs(args))
+			continue
+		}
+
+		fmt.Printf("Running %s hook: %s\n", stage, quoteArgs(args))
+		if err := runHook(ctx, config, h, args, data); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if h.OnFailure == "warn" {
+				fmt.Fprintf(os.Stderr, "Warning: %s hook %q failed: %v\n", stage, expanded, err)
+				continue
+			}
+			return fmt.Errorf("%s hook %q failed: %v", stage, expanded, err)
+		}
+	}
+	return nil
+}
+
+// Run one hook command with the timeout of the hooks
+func runHook(ctx context.Context, config *Config, h *Hooks, args []string, data templateData) error {
+	if h.Timeout != "" {
+		timeout, _ := time.ParseDuration(h.Timeout) // checked on load
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), hookEnv(config, data)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.Timeout)
+	}
+	return err
+}
+
+// Run a stage of the project hooks followed by the target hooks
+func runPlatformHooks(ctx context.Context, config *Config, stage string, data templateData) error {
+	if config.ProjectConfig != nil {
+		if err := runHooks(ctx, config, config.ProjectConfig.Hooks, stage, data); err != nil {
+			return err
+		}
+	}
+	if config.Target != nil {
+		if err := runHooks(ctx, config, config.Target.Hooks, stage, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Absolute path of the built binary for hooks
+func absBinary(binaryName string) string {
+	abs, err := filepath.Abs(binaryName)
+	if err != nil {
+		return binaryName
+	}
+	return abs
+}
@@ -0,0 +1,381 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Inspect built binaries with debug/elf, debug/pe and debug/macho:
+// make sure the binary is for the requested GOOS/GOARCH/GOARM, report
+// how it is linked and whether it is stripped as the ldflags asked
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// binaryInfo is what was found by inspecting a binary
+type binaryInfo struct {
+	Format      string   // ELF, PE or Mach-O
+	Machine     string   // machine type as named by the format
+	Machines    []string // Mach-O universal binaries: machine of each arch
+	ByteOrder   binary.ByteOrder
+	Interpreter string            // ELF dynamic loader
+	Libraries   []string          // shared libraries the binary is linked against
+	Symbols     bool              // symbol table present
+	DWARF       bool              // debug info present
+	Settings    map[string]string // build settings embedded by go, if readable
+}
+
+// Format and machine type for GOARCH
+var elfMachines = map[string]elf.Machine{
+	"386":      elf.EM_386,
+	"amd64":    elf.EM_X86_64,
+	"arm":      elf.EM_ARM,
+	"arm64":    elf.EM_AARCH64,
+	"loong64":  elf.EM_LOONGARCH,
+	"mips":     elf.EM_MIPS,
+	"mipsle":   elf.EM_MIPS,
+	"mips64":   elf.EM_MIPS,
+	"mips64le": elf.EM_MIPS,
+	"ppc64":    elf.EM_PPC64,
+	"ppc64le":  elf.EM_PPC64,
+	"riscv64":  elf.EM_RISCV,
+	"s390x":    elf.EM_S390,
+}
+
+var peMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm":   pe.IMAGE_FILE_MACHINE_ARMNT,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+var peMachineNames = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "IMAGE_FILE_MACHINE_I386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "IMAGE_FILE_MACHINE_AMD64",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "IMAGE_FILE_MACHINE_ARMNT",
+	pe.IMAGE_FILE_MACHINE_ARM64: "IMAGE_FILE_MACHINE_ARM64",
+}
+
+// Name of a PE machine type
+func peMachineName(m uint16) string {
+	if name, ok := peMachineNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", m)
+}
+
+var machoCpus = map[string]macho.Cpu{
+	"386":   macho.Cpu386,
+	"amd64": macho.CpuAmd64,
+	"arm":   macho.CpuArm,
+	"arm64": macho.CpuArm64,
+}
+
+// Big endian GOARCHes, everything else is little endian
+var bigEndian = map[string]bool{
+	"mips":   true,
+	"mips64": true,
+	"ppc64":  true,
+	"s390x":  true,
+}
+
+// Executable format of GOOS, empty if it is not inspected
+func binaryFormat(goos string) string {
+	switch goos {
+	case "windows":
+		return "PE"
+	case "darwin", "ios":
+		return "Mach-O"
+	case "aix", "js", "plan9", "wasip1":
+		return ""
+	}
+	return "ELF"
+}
+
+// Check if a section name is DWARF debug info
+func isDebugSection(name string) bool {
+	for _, prefix := range []string{".debug_", ".zdebug_", "__debug_", "__zdebug_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect a binary
+func inspectBinary(path string) (*binaryInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic [4]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var info *binaryInfo
+	switch {
+	case string(magic[:]) == elf.ELFMAG:
+		info, err = inspectELF(f)
+	case magic[0] == 'M' && magic[1] == 'Z':
+		info, err = inspectPE(f)
+	case binary.BigEndian.Uint32(magic[:]) == macho.MagicFat:
+		info, err = inspectFat(f)
+	default:
+		info, err = inspectMachO(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a known executable: %v", path, err)
+	}
+
+	// GOOS, GOARCH, GOARM, -ldflags ... as recorded by go build
+	if bi, err := buildinfo.Read(f); err == nil {
+		info.Settings = make(map[string]string)
+		for _, s := range bi.Settings {
+			info.Settings[s.Key] = s.Value
+		}
+	}
+	return info, nil
+}
+
+func inspectELF(f *os.File) (*binaryInfo, error) {
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	info := &binaryInfo{
+		Format:    "ELF",
+		Machine:   ef.Machine.String(),
+		ByteOrder: ef.ByteOrder,
+	}
+	for _, prog := range ef.Progs {
+		if prog.Type == elf.PT_INTERP {
+			data := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(data, 0); err == nil {
+				info.Interpreter = strings.TrimRight(string(data), "\x00")
+			}
+		}
+	}
+	info.Libraries, _ = ef.ImportedLibraries()
+	for _, s := range ef.Sections {
+		if s.Type == elf.SHT_SYMTAB {
+			info.Symbols = true
+		}
+		if isDebugSection(s.Name) {
+			info.DWARF = true
+		}
+	}
+	return info, nil
+}
+
+func inspectPE(f *os.File) (*binaryInfo, error) {
+	pf, err := pe.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	info := &binaryInfo{
+		Format:    "PE",
+		Machine:   peMachineName(pf.Machine),
+		ByteOrder: binary.LittleEndian,
+		Symbols:   pf.NumberOfSymbols > 0,
+	}
+	// debug/pe does not implement ImportedLibraries, the DLLs are taken
+	// from the imported symbols (name:dll)
+	symbols, _ := pf.ImportedSymbols()
+	seen := make(map[string]bool)
+	for _, sym := range symbols {
+		if i := strings.LastIndex(sym, ":"); i >= 0 {
+			dll := strings.ToLower(sym[i+1:])
+			if !seen[dll] {
+				seen[dll] = true
+				info.Libraries = append(info.Libraries, dll)
+			}
+		}
+	}
+	for _, s := range pf.Sections {
+		if isDebugSection(s.Name) {
+			info.DWARF = true
+		}
+	}
+	return info, nil
+}
+
+func inspectMachO(f *os.File) (*binaryInfo, error) {
+	mf, err := macho.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+	return machOInfo(mf), nil
+}
+
+func machOInfo(mf *macho.File) *binaryInfo {
+	info := &binaryInfo{
+		Format:    "Mach-O",
+		Machine:   mf.Cpu.String(),
+		ByteOrder: mf.ByteOrder,
+	}
+	info.Libraries, _ = mf.ImportedLibraries()
+	// A stripped binary only has the undefined symbols it imports
+	if mf.Symtab != nil {
+		for _, sym := range mf.Symtab.Syms {
+			if sym.Sect != 0 {
+				info.Symbols = true
+				break
+			}
+		}
+	}
+	for _, s := range mf.Sections {
+		if isDebugSection(s.Name) {
+			info.DWARF = true
+		}
+	}
+	return info
+}
+
+// Universal binaries: the first architecture is reported, all machines
+// are listed
+func inspectFat(f *os.File) (*binaryInfo, error) {
+	ff, err := macho.NewFatFile(f)
+	if err != nil {
+		return nil, err
+	}
+	info := machOInfo(ff.Arches[0].File)
+	info.Format = "Mach-O universal"
+	for _, arch := range ff.Arches {
+		info.Machines = append(info.Machines, arch.Cpu.String())
+	}
+	return info, nil
+}
+
+// Check the inspected binary against the platform it was built for and
+// the ldflags it was built with. Returns the problems (wrong platform) and
+// the warnings (not stripped as the ldflags asked).
+func checkBinaryInfo(info *binaryInfo, p platform, ldflags string) (problems, warnings []string) {
+	format := binaryFormat(p.GOOS)
+	if format != "" && !strings.HasPrefix(info.Format, format) {
+		problems = append(problems, fmt.Sprintf("%s binary, expected %s for %s", info.Format, format, p.GOOS))
+		return problems, warnings
+	}
+
+	var expected string
+	switch format {
+	case "ELF":
+		if m, ok := elfMachines[p.GOARCH]; ok {
+			expected = m.String()
+		}
+	case "PE":
+		if m, ok := peMachines[p.GOARCH]; ok {
+			expected = peMachineName(m)
+		}
+	case "Mach-O":
+		if m, ok := machoCpus[p.GOARCH]; ok {
+			expected = m.String()
+		}
+	}
+	if expected != "" && len(info.Machines) == 0 && info.Machine != expected {
+		problems = append(problems, fmt.Sprintf("machine %s, expected %s for %s", info.Machine, expected, p.GOARCH))
+	}
+	if format == "ELF" {
+		var order binary.ByteOrder = binary.LittleEndian
+		if bigEndian[p.GOARCH] {
+			order = binary.BigEndian
+		}
+		if info.ByteOrder != order {
+			problems = append(problems, fmt.Sprintf("byte order %s, expected %s for %s", info.ByteOrder, order, p.GOARCH))
+		}
+	}
+
+	// What go build recorded in the binary
+	if info.Settings != nil {
+		if goos := info.Settings["GOOS"]; goos != "" && goos != p.GOOS {
+			problems = append(problems, fmt.Sprintf("built for GOOS %s, expected %s", goos, p.GOOS))
+		}
+		if goarch := info.Settings["GOARCH"]; goarch != "" && goarch != p.GOARCH {
+			problems = append(problems, fmt.Sprintf("built for GOARCH %s, expected %s", goarch, p.GOARCH))
+		}
+		// GOARM can have a suffix, e.g. 6,softfloat
+		goarm := strings.Split(info.Settings["GOARM"], ",")[0]
+		if p.GOARM != "" && goarm != "" && goarm != p.GOARM {
+			problems = append(problems, fmt.Sprintf("built for GOARM %s, expected %s", goarm, p.GOARM))
+		}
+	}
+
+	// ldflags are not in the build info with -trimpath, the ones passed
+	// to go build are checked
+	flags, _ := parseArguments(ldflags)
+	for _, flag := range flags {
+		if flag == "-s" && info.Symbols {
+			warnings = append(warnings, "ldflags has -s but the symbol table is present")
+		}
+		if flag == "-w" && info.DWARF {
+			warnings = append(warnings, "ldflags has -w but DWARF debug info is present")
+		}
+	}
+	return problems, warnings
+}
+
+// Get the ldflags go build gets, the last -ldflags of its arguments
+func buildLdFlags(config *Config) string {
+	args, err := gobuildArgs(config, "", "")
+	if err != nil {
+		return config.LdFlags
+	}
+	ldflags := ""
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-ldflags="), strings.HasPrefix(arg, "--ldflags="):
+			ldflags = arg[strings.Index(arg, "=")+1:]
+		case (arg == "-ldflags" || arg == "--ldflags") && i+1 < len(args):
+			ldflags = args[i+1]
+		}
+	}
+	return ldflags
+}
+
+// Describe how the binary is linked
+func (info *binaryInfo) linkage() string {
+	switch {
+	case info.Interpreter != "":
+		return fmt.Sprintf("dynamically linked (interpreter %s, libs: %s)", info.Interpreter, strings.Join(info.Libraries, ", "))
+	case len(info.Libraries) > 0:
+		return fmt.Sprintf("dynamically linked (libs: %s)", strings.Join(info.Libraries, ", "))
+	}
+	return "statically linked"
+}
+
+// Inspect the built binary of a platform and check it, the build fails if
+// the binary is not for the platform
+func inspectPlatformBinary(config *Config, binaryName string, p platform) error {
+	info, err := inspectBinary(binaryName)
+	if err != nil {
+		config.Summary.addResult("inspect", binaryName, err)
+		return err
+	}
+
+	stripped := "stripped"
+	if info.Symbols {
+		stripped = "not stripped"
+	}
+	machine := info.Machine
+	if len(info.Machines) > 0 {
+		machine = strings.Join(info.Machines, "+")
+	}
+	fmt.Printf("Inspected %s: %s %s, %s, %s\n", binaryName, info.Format, machine, info.linkage(), stripped)
+
+	problems, warnings := checkBinaryInfo(info, p, buildLdFlags(config))
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", binaryName, w)
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("%s is not a %s binary: %s", binaryName, p.Label, strings.Join(problems, "; "))
+	}
+	config.Summary.addResult("inspect", binaryName, err)
+	return err
+}
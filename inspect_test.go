@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Build a small program with -trimpath and the ldflags into dir
+func buildTestBinary(t *testing.T, dir, ldflags string) string {
+	t.Helper()
+	src := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() { println(\"hello\") }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "hello")
+	if runtime.GOOS == "windows" {
+		out += ".exe"
+	}
+	cmd := exec.Command("go", "build", "-trimpath", "-ldflags="+ldflags, "-o", out, src)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off")
+	if data, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, data)
+	}
+	return out
+}
+
+func TestCheckBinaryInfoLdFlags(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	host := platform{GOOS: runtime.GOOS, GOARCH: runtime.GOARCH}
+
+	stripped, err := inspectBinary(buildTestBinary(t, t.TempDir(), "-s -w"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, warnings := checkBinaryInfo(stripped, host, "-s -w"); len(problems) > 0 || len(warnings) > 0 {
+		t.Errorf("stripped binary: problems %q, warnings %q", problems, warnings)
+	}
+
+	// -trimpath leaves the ldflags out of the build info, the warnings
+	// come from the ldflags passed in
+	unstripped, err := inspectBinary(buildTestBinary(t, t.TempDir(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unstripped.Settings["-ldflags"]; ok {
+		t.Errorf("build info has -ldflags with -trimpath")
+	}
+	problems, warnings := checkBinaryInfo(unstripped, host, "-s -w")
+	if len(problems) > 0 {
+		t.Errorf("unstripped binary: problems %q", problems)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("unstripped binary with -s -w: warnings %q, want 2", warnings)
+	}
+}
+
+func TestBuildLdFlags(t *testing.T) {
+	config := &Config{LdFlags: "-s -w", BuildFlags: "-trimpath"}
+	if got := buildLdFlags(config); got != "-s -w" {
+		t.Errorf("buildLdFlags = %q, want %q", got, "-s -w")
+	}
+	config.ExtraBuildArgs = []string{"-ldflags", "-X main.v=1"}
+	if got := buildLdFlags(config); got != "-X main.v=1" {
+		t.Errorf("buildLdFlags with -build-args = %q, want %q", got, "-X main.v=1")
+	}
+}
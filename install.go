@@ -0,0 +1,423 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// install.sh and install.ps1 of a target: detect the OS/arch,
+// download the matching release archive, verify its sha256 and install
+// the binary. The archive names are the ones of the build, so the
+// scripts can't drift from the naming scheme.
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// InstallScriptsConfig configures the install scripts of a target. The
+// project level section is the default for all targets, a target's
+// section replaces it.
+type InstallScriptsConfig struct {
+	Prefix        string `json:"prefix"`         // install.sh default, default /usr/local/bin
+	WindowsPrefix string `json:"windows_prefix"` // install.ps1 default, default %LOCALAPPDATA%\Programs\<name>
+	Skip          bool   `json:"skip"`           // no install scripts for this target
+}
+
+// Suffixes of the install scripts, <target>-install.sh etc.
+const (
+	installShSuffix  = "-install.sh"
+	installPs1Suffix = "-install.ps1"
+)
+
+// installPlatform is an archive the scripts can install
+type installPlatform struct {
+	key       string // os-arch as detected by the script, e.g. linux-arm7
+	archive   string
+	dir       string // top directory of the archive
+	binary    string
+	url       string
+	checksums string // URL of the file with the sha256, empty if there is none
+}
+
+// Write the install scripts of the targets
+func writeInstallScripts(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	for _, target := range projectConfig.Targets {
+		ic := projectConfig.InstallScripts
+		if target.InstallScripts != nil {
+			ic = target.InstallScripts
+		}
+		if ic == nil || ic.Skip {
+			continue
+		}
+		name := targetOutputName(target)
+		if config.DryRun {
+			fmt.Printf("Would write %s%s and %s%s\n", name, installShSuffix, name, installPs1Suffix)
+			continue
+		}
+		platforms, err := installPlatforms(ctx, config, version, name)
+		if err != nil {
+			return fmt.Errorf("target %s: install scripts: %v", target.Name, err)
+		}
+		var unix, windows []installPlatform
+		for _, p := range platforms {
+			if strings.HasPrefix(p.key, "windows-") {
+				windows = append(windows, p)
+			} else {
+				unix = append(unix, p)
+			}
+		}
+		if len(unix) > 0 {
+			if err := writeManifest(config, "install", name+installShSuffix, installSh(ctx, ic, version, name, unix)); err != nil {
+				return err
+			}
+		}
+		if len(windows) > 0 {
+			if err := writeManifest(config, "install", name+installPs1Suffix, installPs1(ctx, ic, version, name, windows)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The archives of a target by the os-arch key of the scripts. arm is
+// arm7 for GOARM 7 (or unset) and arm6/arm5, so armv7l and armv6l
+// machines get the Raspberry Pi archives if there is no linux/arm build.
+func installPlatforms(ctx context.Context, config *Config, version, name string) ([]installPlatform, error) {
+	var platforms []installPlatform
+	seen := make(map[string]bool)
+	for _, a := range targetArtifacts(config, name) {
+		arch := a.GOARCH
+		if arch == "arm" {
+			arch = "arm7"
+			if a.GOARM == "5" || a.GOARM == "6" {
+				arch = "arm" + a.GOARM
+			}
+		}
+		keys := []string{a.GOOS + "-" + arch}
+		if arch == "universal" {
+			// for the architectures without a per-arch archive, they come first
+			keys = []string{a.GOOS + "-amd64", a.GOOS + "-arm64"}
+		}
+		var unseen []string
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				unseen = append(unseen, key)
+			}
+		}
+		if len(unseen) == 0 {
+			continue
+		}
+		url, err := releaseURL(ctx, config, version, a, a.Archive)
+		if err != nil {
+			return nil, err
+		}
+		checksums := ""
+		if file := sha256FileName(config, version, a); file != "" {
+			if checksums, err = releaseURL(ctx, config, version, a, file); err != nil {
+				return nil, err
+			}
+		}
+		for _, key := range unseen {
+			platforms = append(platforms, installPlatform{
+				key:       key,
+				archive:   a.Archive,
+				dir:       strings.TrimSuffix(strings.TrimSuffix(a.Archive, ".tar.gz"), ".zip"),
+				binary:    a.Binary,
+				url:       url,
+				checksums: checksums,
+			})
+		}
+	}
+	// older ARM archives run on newer CPUs
+	for _, f := range []struct{ arch, older string }{{"arm6", "arm5"}, {"arm7", "arm6"}} {
+		for _, p := range platforms {
+			goos := strings.TrimSuffix(p.key, "-"+f.older)
+			if goos == p.key || seen[goos+"-"+f.arch] {
+				continue
+			}
+			seen[goos+"-"+f.arch] = true
+			p.key = goos + "-" + f.arch
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms, nil
+}
+
+// install.sh for the unix archives
+func installSh(ctx context.Context, ic *InstallScriptsConfig, version, name string, platforms []installPlatform) string {
+	prefix := ic.Prefix
+	if prefix == "" {
+		prefix = "/usr/local/bin"
+	}
+	// names and URLs with the version replaced by ${version}
+	quote := func(s string) string {
+		s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+		return `"` + strings.ReplaceAll(s, version, "${version}") + `"`
+	}
+	var cases strings.Builder
+	for _, p := range platforms {
+		fmt.Fprintf(&cases, "  %s)\n", p.key)
+		fmt.Fprintf(&cases, "    archive=%s\n", quote(p.archive))
+		fmt.Fprintf(&cases, "    dir=%s\n", quote(p.dir))
+		fmt.Fprintf(&cases, "    binary=%s\n", quote(p.binary))
+		fmt.Fprintf(&cases, "    url=%s\n", quote(p.url))
+		fmt.Fprintf(&cases, "    checksums=%s\n", quote(p.checksums))
+		fmt.Fprintf(&cases, "    ;;\n")
+	}
+	return strings.NewReplacer(
+		"@NAME@", name,
+		"@GENERATOR@", me+" "+versionString(),
+		"@VERSION@", version,
+		"@PREFIX@", prefix,
+		"@GITHUB@", githubRepository(ctx),
+		"@PLATFORMS@", cases.String(),
+	).Replace(installShTemplate)
+}
+
+// install.ps1 for the windows archives
+func installPs1(ctx context.Context, ic *InstallScriptsConfig, version, name string, platforms []installPlatform) string {
+	prefix := "Join-Path $env:LOCALAPPDATA 'Programs\\" + name + "'"
+	if ic.WindowsPrefix != "" {
+		prefix = "'" + strings.ReplaceAll(ic.WindowsPrefix, "'", "''") + "'"
+	}
+	// names and URLs with the version replaced by ${Version}
+	quote := func(s string) string {
+		s = strings.NewReplacer("`", "``", "$", "`$", `"`, "`\"").Replace(s)
+		return `"` + strings.ReplaceAll(s, version, "${Version}") + `"`
+	}
+	archs := map[string]string{"windows-amd64": "AMD64", "windows-arm64": "ARM64", "windows-386": "x86"}
+	var cases strings.Builder
+	for _, p := range platforms {
+		arch, ok := archs[p.key]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&cases, "    '%s' {\n", arch)
+		fmt.Fprintf(&cases, "        $archive = %s\n", quote(p.archive))
+		fmt.Fprintf(&cases, "        $dir = %s\n", quote(p.dir))
+		fmt.Fprintf(&cases, "        $binary = %s\n", quote(p.binary))
+		fmt.Fprintf(&cases, "        $url = %s\n", quote(p.url))
+		fmt.Fprintf(&cases, "        $checksums = %s\n", quote(p.checksums))
+		fmt.Fprintf(&cases, "    }\n")
+	}
+	return strings.NewReplacer(
+		"@NAME@", name,
+		"@GENERATOR@", me+" "+versionString(),
+		"@VERSION@", version,
+		"@PREFIX@", prefix,
+		"@GITHUB@", githubRepository(ctx),
+		"@PLATFORMS@", cases.String(),
+	).Replace(installPs1Template)
+}
+
+// Whether a file in the bin directory is an install script
+func isInstallScript(name string) bool {
+	return strings.HasSuffix(name, installShSuffix) || strings.HasSuffix(name, installPs1Suffix)
+}
+
+const installShTemplate = `#!/bin/sh
+# Install @NAME@ from the release archives.
+# Generated by @GENERATOR@, do not edit.
+#
+# Usage: sh @NAME@-install.sh [-v version] [-p prefix]
+#   -v version  version to install, e.g. @VERSION@ (default: latest)
+#   -p prefix   directory to install @NAME@ to (default: @PREFIX@)
+# VERSION and PREFIX can be set in the environment too.
+set -eu
+
+name='@NAME@'
+version="${VERSION:-latest}"
+prefix="${PREFIX:-@PREFIX@}"
+default_version='@VERSION@'
+github_repo='@GITHUB@'
+
+fail() {
+  echo "$name: $*" >&2
+  exit 1
+}
+
+while getopts 'v:p:h' opt; do
+  case "$opt" in
+    v) version="$OPTARG" ;;
+    p) prefix="$OPTARG" ;;
+    *) echo "usage: $0 [-v version] [-p prefix]" >&2; exit 2 ;;
+  esac
+done
+
+download() {
+  if command -v curl >/dev/null 2>&1; then
+    curl -fsSL -o "$2" "$1"
+  elif command -v wget >/dev/null 2>&1; then
+    wget -q -O "$2" "$1"
+  else
+    fail "curl or wget is required"
+  fi
+}
+
+if [ "$version" = latest ]; then
+  version="$default_version"
+  if [ -n "$github_repo" ] && command -v curl >/dev/null 2>&1; then
+    latest=$(curl -fsSLI -o /dev/null -w '%{url_effective}' "https://github.com/$github_repo/releases/latest" || true)
+    case "$latest" in
+      */releases/tag/*) version="${latest##*/}" ;;
+    esac
+  fi
+fi
+case "$default_version" in
+  v*) case "$version" in v*) ;; *) version="v$version" ;; esac ;;
+esac
+
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+arch=$(uname -m)
+case "$arch" in
+  x86_64 | amd64) arch=amd64 ;;
+  aarch64 | arm64) arch=arm64 ;;
+  armv7* | armv8l) arch=arm7 ;;
+  armv6*) arch=arm6 ;;
+  armv5*) arch=arm5 ;;
+  i386 | i486 | i586 | i686) arch=386 ;;
+  loongarch64) arch=loong64 ;;
+esac
+
+case "$os-$arch" in
+@PLATFORMS@  *)
+    fail "no release archive for $os/$arch"
+    ;;
+esac
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT INT TERM
+
+echo "Downloading $url"
+download "$url" "$tmp/$archive" || fail "failed to download $url"
+
+if [ -n "$checksums" ]; then
+  download "$checksums" "$tmp/checksums" || fail "failed to download $checksums"
+  want=$(awk -v f="$archive" '$2 == f || $2 == "*" f { print $1; exit }' "$tmp/checksums")
+  [ -n "$want" ] || fail "$archive is not in $checksums"
+  if command -v sha256sum >/dev/null 2>&1; then
+    got=$(sha256sum "$tmp/$archive" | awk '{ print $1 }')
+  elif command -v shasum >/dev/null 2>&1; then
+    got=$(shasum -a 256 "$tmp/$archive" | awk '{ print $1 }')
+  else
+    fail "sha256sum or shasum is required to verify the download"
+  fi
+  [ "$got" = "$want" ] || fail "sha256 mismatch for $archive: got $got, want $want"
+  echo "Verified sha256 $got"
+else
+  echo "$name: no sha256 checksums published, the download is not verified" >&2
+fi
+
+tar -xzf "$tmp/$archive" -C "$tmp"
+[ -f "$tmp/$dir/$binary" ] || fail "$binary not found in $archive"
+
+if mkdir -p "$prefix" 2>/dev/null && [ -w "$prefix" ]; then
+  install -m 755 "$tmp/$dir/$binary" "$prefix/$name"
+else
+  echo "Installing to $prefix with sudo"
+  sudo mkdir -p "$prefix"
+  sudo install -m 755 "$tmp/$dir/$binary" "$prefix/$name"
+fi
+echo "Installed $name $version to $prefix/$name"
+`
+
+const installPs1Template = `# Install @NAME@ from the release archives.
+# Generated by @GENERATOR@, do not edit.
+#
+# Usage: @NAME@-install.ps1 [-Version version] [-Prefix directory]
+#   -Version  version to install, e.g. @VERSION@ (default: latest)
+#   -Prefix   directory to install @NAME@.exe to
+# VERSION and PREFIX can be set in the environment too.
+param(
+    [string]$Version = $(if ($env:VERSION) { $env:VERSION } else { 'latest' }),
+    [string]$Prefix = $(if ($env:PREFIX) { $env:PREFIX } else { @PREFIX@ })
+)
+
+$ErrorActionPreference = 'Stop'
+$ProgressPreference = 'SilentlyContinue'
+
+$name = '@NAME@'
+$defaultVersion = '@VERSION@'
+$githubRepo = '@GITHUB@'
+
+if ($Version -eq 'latest') {
+    $Version = $defaultVersion
+    if ($githubRepo) {
+        try {
+            $response = Invoke-WebRequest -UseBasicParsing -Method Head -Uri "https://github.com/$githubRepo/releases/latest"
+            if ($response.BaseResponse.ResponseUri) {
+                $latest = $response.BaseResponse.ResponseUri.AbsoluteUri
+            } else {
+                $latest = $response.BaseResponse.RequestMessage.RequestUri.AbsoluteUri
+            }
+            if ($latest -match '/releases/tag/([^/]+)$') {
+                $Version = $Matches[1]
+            }
+        } catch {
+        }
+    }
+}
+if ($defaultVersion.StartsWith('v') -and -not $Version.StartsWith('v')) {
+    $Version = "v$Version"
+}
+
+$arch = $env:PROCESSOR_ARCHITECTURE
+if ($env:PROCESSOR_ARCHITEW6432) {
+    $arch = $env:PROCESSOR_ARCHITEW6432
+}
+switch ($arch) {
+@PLATFORMS@    default {
+        throw "${name}: no release archive for windows/$arch"
+    }
+}
+
+$tmp = Join-Path ([IO.Path]::GetTempPath()) ([IO.Path]::GetRandomFileName())
+New-Item -ItemType Directory -Path $tmp | Out-Null
+try {
+    $zip = Join-Path $tmp $archive
+    Write-Host "Downloading $url"
+    Invoke-WebRequest -UseBasicParsing -Uri $url -OutFile $zip
+
+    if ($checksums) {
+        $sums = Join-Path $tmp 'checksums'
+        Invoke-WebRequest -UseBasicParsing -Uri $checksums -OutFile $sums
+        $want = $null
+        foreach ($line in Get-Content $sums) {
+            $fields = -split $line
+            if ($fields.Count -ge 2 -and $fields[1].TrimStart('*') -eq $archive) {
+                $want = $fields[0].ToLower()
+                break
+            }
+        }
+        if (-not $want) {
+            throw "${name}: $archive is not in $checksums"
+        }
+        $got = (Get-FileHash -Algorithm SHA256 -Path $zip).Hash.ToLower()
+        if ($got -ne $want) {
+            throw "${name}: sha256 mismatch for ${archive}: got $got, want $want"
+        }
+        Write-Host "Verified sha256 $got"
+    } else {
+        Write-Warning "${name}: no sha256 checksums published, the download is not verified"
+    }
+
+    Expand-Archive -Path $zip -DestinationPath $tmp
+    $exe = Join-Path (Join-Path $tmp $dir) $binary
+    if (-not (Test-Path $exe)) {
+        throw "${name}: $binary not found in $archive"
+    }
+    New-Item -ItemType Directory -Force -Path $Prefix | Out-Null
+    $target = Join-Path $Prefix "$name.exe"
+    Copy-Item -Force -Path $exe -Destination $target
+    Write-Host "Installed $name $Version to $target"
+
+    $path = [Environment]::GetEnvironmentVariable('Path', 'User')
+    if (($path -split ';') -notcontains $Prefix) {
+        Write-Host "Add $Prefix to your PATH to run $name"
+    }
+} finally {
+    Remove-Item -Recurse -Force -Path $tmp
+}
+`
@@ -0,0 +1,233 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Bundle the licenses of third party modules in every archive. The
+// modules are taken from the build info of the built binary, their
+// LICENSE/NOTICE/COPYING files from the local module cache
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Name of the directory or file written to the dist directory
+const thirdPartyLicenses = "THIRD_PARTY_LICENSES"
+
+// LicensesConfig configures bundling of third party licenses
+type LicensesConfig struct {
+	Format       string   `json:"format"`        // "dir" (default): one directory per module, "file": concatenated
+	AllowMissing []string `json:"allow_missing"` // modules allowed to have no license file
+}
+
+// licenseFile is a license file of a module
+type licenseFile struct {
+	Name string
+	Data []byte
+}
+
+// moduleLicenses are the license files of a module
+type moduleLicenses struct {
+	Module string // path@version
+	Files  []licenseFile
+}
+
+// License files found so far, by path@version
+var licenseCache = make(map[string][]licenseFile)
+
+// Validate the licenses config
+func checkLicensesConfig(lc *LicensesConfig) error {
+	if lc == nil {
+		return nil
+	}
+	switch lc.Format {
+	case "", "dir", "file":
+	default:
+		return fmt.Errorf("unknown licenses format %q (supported: dir, file)", lc.Format)
+	}
+	return nil
+}
+
+// Check if a file name looks like a license file
+func isLicenseFile(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "UNLICENSE", "COPYING", "NOTICE"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Escape a module path for the module cache, upper case letters are
+// written as ! followed by the lower case letter
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Get output of go env for a variable
+func goEnv(ctx context.Context, name string) (string, error) {
+	out, err := exec.CommandContext(ctx, "go", "env", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("go env %s failed: %v", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Get the directory of a module. A replacement without version is a local
+// directory, anything else is looked up in the module cache and downloaded
+// if it is not there
+func moduleDir(ctx context.Context, path, version string) (string, error) {
+	if version == "" {
+		return filepath.Abs(path)
+	}
+	modCache, err := goEnv(ctx, "GOMODCACHE")
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(modCache, escapeModulePath(path)+"@"+version)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	out, err := exec.CommandContext(ctx, "go", "mod", "download", "-json", path+"@"+version).Output()
+	if err != nil {
+		return "", fmt.Errorf("module %s@%s is not in the module cache and could not be downloaded: %v", path, version, err)
+	}
+	var dl struct{ Dir string }
+	if err := json.Unmarshal(out, &dl); err != nil || dl.Dir == "" {
+		return "", fmt.Errorf("failed to get directory of module %s@%s", path, version)
+	}
+	return dl.Dir, nil
+}
+
+// Read the license files at the top of a directory
+func readLicenseFiles(dir string) ([]licenseFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []licenseFile
+	for _, e := range entries {
+		if e.IsDir() || !isLicenseFile(e.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, licenseFile{Name: e.Name(), Data: data})
+	}
+	return files, nil
+}
+
+// Get the license files of the modules linked into a binary, including the
+// go standard library. Fails if a module has no license file and it is not
+// in allow_missing.
+func collectLicenses(ctx context.Context, lc *LicensesConfig, binaryName string) ([]moduleLicenses, error) {
+	bi, err := buildinfo.ReadFile(binaryName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build info of %s: %v", binaryName, err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, m := range lc.AllowMissing {
+		allowed[m] = true
+	}
+
+	var result []moduleLicenses
+	var missing []string
+
+	// The go standard library
+	if goroot, err := goEnv(ctx, "GOROOT"); err == nil {
+		if files, err := readLicenseFiles(goroot); err == nil && len(files) > 0 {
+			result = append(result, moduleLicenses{Module: "go@" + bi.GoVersion, Files: files})
+		}
+	}
+
+	for _, dep := range bi.Deps {
+		m := usedModule(dep)
+		key := m.Path + "@" + m.Version
+		files, ok := licenseCache[key]
+		if !ok {
+			dir, err := moduleDir(ctx, m.Path, m.Version)
+			if err != nil {
+				return nil, err
+			}
+			if files, err = readLicenseFiles(dir); err != nil {
+				return nil, fmt.Errorf("failed to read licenses of %s: %v", key, err)
+			}
+			licenseCache[key] = files
+		}
+		if len(files) == 0 {
+			if !allowed[dep.Path] && !allowed[m.Path] {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		result = append(result, moduleLicenses{Module: dep.Path + "@" + dep.Version, Files: files})
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no license file found for %s (add to licenses allow_missing if this is fine)", strings.Join(missing, ", "))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Module < result[j].Module })
+	return result, nil
+}
+
+// Write the third party licenses of a binary to the dist directory, either
+// as THIRD_PARTY_LICENSES/<module>@<version>/<file> or one concatenated
+// THIRD_PARTY_LICENSES file
+func writeThirdPartyLicenses(ctx context.Context, config *Config, binaryName, distDir string) error {
+	lc := config.Licenses
+	licenses, err := collectLicenses(ctx, lc, binaryName)
+	if err != nil {
+		return err
+	}
+
+	if lc.Format == "file" {
+		var buf bytes.Buffer
+		for i, ml := range licenses {
+			for j, f := range ml.Files {
+				if i > 0 || j > 0 {
+					buf.WriteString("\n")
+				}
+				fmt.Fprintf(&buf, "================================================================\n")
+				fmt.Fprintf(&buf, "%s - %s\n", ml.Module, f.Name)
+				fmt.Fprintf(&buf, "================================================================\n\n")
+				buf.Write(f.Data)
+			}
+		}
+		return os.WriteFile(filepath.Join(distDir, thirdPartyLicenses), buf.Bytes(), 0644)
+	}
+
+	for _, ml := range licenses {
+		dir := filepath.Join(distDir, thirdPartyLicenses, filepath.FromSlash(ml.Module))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for _, f := range ml.Files {
+			if err := os.WriteFile(filepath.Join(dir, f.Name), f.Data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
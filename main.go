@@ -14,8 +14,7 @@ import (
 	"archive/zip"
 	"bufio"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -23,9 +22,12 @@ import (
 	"io/fs"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/muquit/go-xbuild-go/pkg/version"
 )
@@ -45,6 +47,23 @@ type BuildTarget struct {
 	LdFlags         string   `json:"ldflags"`         // Custom ldflags (optional)
 	BuildFlags      string   `json:"build_flags"`     // Custom build flags (optional)
 	AdditionalFiles []string `json:"additional_files"` // Target-specific additional files
+	Env             map[string]string            `json:"env"`          // Environment for go build (optional)
+	PlatformEnv     map[string]map[string]string `json:"platform_env"` // Environment per platform, e.g. "linux/arm64" (optional)
+	CgoToolchain    string                       `json:"cgo_toolchain"` // "zig" to cross compile cgo with zig, "none" to turn off (optional)
+	Zig             *ZigConfig                   `json:"zig"`           // zig settings (optional)
+	Hooks           *Hooks                       `json:"hooks"`         // Commands run around the build (optional)
+	SmokeTest       *SmokeTest                   `json:"smoke_test"`    // Run the built binary before archiving (optional)
+	MaxSize         string                       `json:"max_size"`      // Fail if the binary is larger, e.g. "12MB" (optional)
+	Packages        *PackagesConfig              `json:"packages"`      // Linux packages, overrides the project's (optional)
+	Homebrew        *HomebrewConfig              `json:"homebrew"`      // Homebrew formula, replaces the project's (optional)
+	Scoop           *ScoopConfig                 `json:"scoop"`         // Scoop manifest, replaces the project's (optional)
+	Winget          *WingetConfig                `json:"winget"`        // winget manifests, replace the project's (optional)
+	Nix             *NixConfig                   `json:"nix"`           // Nix derivation, replaces the project's (optional)
+	AUR             *AURConfig                   `json:"aur"`           // AUR -bin package, replaces the project's (optional)
+	InstallScripts  *InstallScriptsConfig        `json:"install_scripts"` // install.sh and install.ps1, replace the project's (optional)
+	Universal       *UniversalConfig             `json:"macos_universal"` // macOS universal binary, replaces the project's (optional)
+	WindowsResources *WindowsResourcesConfig     `json:"windows_resources"` // Windows resources, replace the project's (optional)
+	OCI             *OCIConfig                   `json:"oci"`           // Container image, replaces the project's (optional)
 }
 
 // ProjectConfig represents the configuration for a multi-binary project
@@ -56,6 +75,30 @@ type ProjectConfig struct {
 	DefaultLdFlags  string        `json:"default_ldflags"`
 	DefaultBuildFlags string      `json:"default_build_flags"`
 	GlobalAdditionalFiles []string `json:"global_additional_files"`
+	Env             map[string]string            `json:"env"`          // Environment for go build of all targets
+	PlatformEnv     map[string]map[string]string `json:"platform_env"` // Environment per platform for all targets
+	CgoToolchain    string                       `json:"cgo_toolchain"` // "zig" to cross compile cgo with zig
+	Zig             *ZigConfig                   `json:"zig"`           // zig settings
+	Hooks           *Hooks                       `json:"hooks"`         // Commands run around the build
+	Checks          *ChecksConfig                `json:"checks"`        // go vet/go test before building
+	Inspect         bool                         `json:"inspect"`       // Verify the OS/arch and linkage of built binaries
+	SizeReport      *SizeReportConfig            `json:"size_report"`   // Report binary and archive sizes
+	SBOM            bool                         `json:"sbom"`          // CycloneDX and SPDX SBOM for every binary
+	Licenses        *LicensesConfig              `json:"licenses"`      // Bundle third party licenses in the archives
+	Sign            *SignConfig                  `json:"sign"`          // Detached signatures of checksums and archives
+	Checksums       *ChecksumsConfig             `json:"checksums"`     // Checksum algorithms and files
+	Provenance      *ProvenanceConfig            `json:"provenance"`    // SLSA provenance statement
+	Universal       *UniversalConfig             `json:"macos_universal"` // macOS universal binaries of all targets
+	WindowsResources *WindowsResourcesConfig     `json:"windows_resources"` // Icon, version info and manifest of windows binaries
+	OCI             *OCIConfig                   `json:"oci"`           // Container images of all targets
+	Packages        *PackagesConfig              `json:"packages"`      // Linux packages of all targets
+	ReleaseURL      string                       `json:"release_url"`   // Download URL of release files, templated
+	Homebrew        *HomebrewConfig              `json:"homebrew"`      // Homebrew formulas of all targets
+	Scoop           *ScoopConfig                 `json:"scoop"`         // Scoop manifests of all targets
+	Winget          *WingetConfig                `json:"winget"`        // winget manifests of all targets
+	Nix             *NixConfig                   `json:"nix"`           // Nix derivations, default.nix and flake.nix
+	AUR             *AURConfig                   `json:"aur"`           // AUR -bin packages of all targets
+	InstallScripts  *InstallScriptsConfig        `json:"install_scripts"` // install.sh and install.ps1 of all targets
 	Targets         []BuildTarget `json:"targets"`
 }
 
@@ -71,6 +114,23 @@ type Config struct {
 	AdditionalFiles []string
 	ProjectConfig   *ProjectConfig // New: multi-target config
 	ExtraBuildArgs  []string
+	Summary         *buildSummary // What finished, shared by all targets
+	Target          *BuildTarget  // Target being built (multi-target mode)
+	DryRun          bool          // Print the build plan only
+	Checks          *ChecksConfig // go vet/go test before building
+	Inspect         bool          // Verify the OS/arch and linkage of built binaries
+	SizeReport      *SizeReportConfig // Report binary and archive sizes
+	Artifacts       *artifactList     // What was built, saved as artifacts.json
+	SBOM            bool              // CycloneDX and SPDX SBOM for every binary
+	Licenses        *LicensesConfig   // Bundle third party licenses in the archives
+	Signer          *signer           // Signs checksums and archives, nil if not signing
+	Checksums       *ChecksumsConfig  // Checksum algorithms and files, sha256 combined file if nil
+	Provenance      *ProvenanceConfig // SLSA provenance statement, nil if not written
+	Packages        *PackagesConfig   // Linux packages of the target being built
+	Universal       *UniversalConfig  // macOS universal binary, nil if not built
+	WindowsResources *WindowsResourcesConfig // Icon, version info and manifest of windows binaries
+	OCI             *OCIConfig        // Container image of the target being built
+	PackageNames    packageNames      // Packages built for the target being built
 }
 
 func main() {
@@ -84,6 +144,17 @@ func main() {
 	var listTargets bool
 	var buildArgs string
 	var platformsFile string
+	var dryRun bool
+	var runChecksFlag bool
+	var inspect bool
+	var sizeReport bool
+	var sbom bool
+	var licenses bool
+	var sign bool
+	var provenance bool
+	var universal bool
+	var universalReplace bool
+	var signKey string
 
 	flag.StringVar(&buildArgs, "build-args", "", "Additional go build arguments (e.g., '-tags systray -race')")
 	flag.BoolVar(&showVersion, "version", false, "Show version information and exit")
@@ -98,6 +169,17 @@ func main() {
 	flag.StringVar(&platformsFile,"platforms-file","platforms.txt","Path of platforms.txt")
 
 	flag.BoolVar(&listTargets, "list-targets", false, "List available build targets and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the go build command and environment for each target/platform without building")
+	flag.BoolVar(&runChecksFlag, "checks", false, "Run go vet for every platform and go test for the host platform before building")
+	flag.BoolVar(&inspect, "inspect", false, "Verify that built binaries match the platform, report linkage and stripping")
+	flag.BoolVar(&sizeReport, "size-report", false, "Report binary and archive sizes with the change from the previous build")
+	flag.BoolVar(&sbom, "sbom", false, "Create CycloneDX and SPDX SBOMs for every binary")
+	flag.BoolVar(&licenses, "licenses", false, "Bundle the licenses of third party modules in a THIRD_PARTY_LICENSES directory in the archives")
+	flag.BoolVar(&sign, "sign", false, "Sign the checksums files with the minisign key in $XBUILD_SIGN_KEY (password in $XBUILD_SIGN_PASSWORD)")
+	flag.BoolVar(&provenance, "provenance", false, "Write an in-toto SLSA v1 provenance statement for the archives and SBOMs")
+	flag.BoolVar(&universal, "universal", false, "Merge the darwin/amd64 and darwin/arm64 binaries into a darwin-universal archive")
+	flag.BoolVar(&universalReplace, "universal-replace", false, "Like -universal, and remove the darwin-amd64 and darwin-arm64 archives")
+	flag.StringVar(&signKey, "sign-key", "", "Sign the checksums files with this minisign or PEM ed25519 secret key file")
 
 flag.Usage = func() {
 	// Determine output destination - stdout if help explicitly requested, stderr otherwise
@@ -115,7 +197,8 @@ flag.Usage = func() {
 	fmt.Fprintf(out, "Usage:\n")
 	fmt.Fprintf(out, "  %s [options]                    # Build using defaults or config file\n", me)
 	fmt.Fprintf(out, "  %s -config build-config.json   # Build using custom config\n", me)
-	fmt.Fprintf(out, "  %s -release                     # Create GitHub release from ./bin\n\n", me)
+	fmt.Fprintf(out, "  %s -release                     # Create GitHub release from ./bin\n", me)
+	fmt.Fprintf(out, "  %s verify [-key minisign.pub] [dir] # Check checksums, signatures and archives\n\n", me)
 	
 	fmt.Fprintf(out, "Quick Start:\n")
 	fmt.Fprintf(out, "  1. Create/edit platforms.txt (uncomment desired platforms)\n")
@@ -131,6 +214,9 @@ flag.Usage = func() {
 	fmt.Fprintf(out, "\nEnvironment Variables (for GitHub release):\n")
 	fmt.Fprintf(out, "  GITHUB_TOKEN     GitHub API token (required for -release)\n")
 	fmt.Fprintf(out, "  GH_CLI_PATH      Custom path to GitHub CLI executable (optional)\n")
+	fmt.Fprintf(out, "\nEnvironment Variables (for signing):\n")
+	fmt.Fprintf(out, "  XBUILD_SIGN_KEY       Secret key if no key file is given (optional)\n")
+	fmt.Fprintf(out, "  XBUILD_SIGN_PASSWORD  Password of the secret key or gpg passphrase (optional)\n")
 	
 	fmt.Fprintf(out, "\nAutomatically Included Files:\n")
 	fmt.Fprintf(out, "  README.md, LICENSE.txt, LICENSE, platforms.txt, <project>.1\n")
@@ -165,6 +251,16 @@ flag.Usage = func() {
 	fmt.Fprintf(out, "Please consult documentaiton for details\n")
 }
 
+	// verify subcommand has its own flags
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := runVerify(ctx, os.Args[2:])
+		stop()
+		if err != nil {
+			fail(err.Error())
+		}
+		os.Exit(0)
+	}
 
 	flag.Parse()
 
@@ -193,6 +289,11 @@ flag.Usage = func() {
 		ChecksumsFile: "checksums.txt",
 		LdFlags:       "-s -w",
 		BuildFlags:    "-trimpath",
+		Summary:       &buildSummary{},
+		DryRun:        dryRun,
+		Inspect:       inspect,
+		Artifacts:     &artifactList{},
+		SBOM:          sbom,
 	}
 
 	// specify an alternate one
@@ -224,6 +325,67 @@ flag.Usage = func() {
 				config.PlatformsFile = filepath.Join(myDir, projectConfig.PlatformsFile)
 			}
 		}
+		config.Checks = projectConfig.Checks
+		config.Inspect = config.Inspect || projectConfig.Inspect
+		config.SizeReport = projectConfig.SizeReport
+		config.SBOM = config.SBOM || projectConfig.SBOM
+		config.Licenses = projectConfig.Licenses
+		config.Checksums = projectConfig.Checksums
+		config.Provenance = projectConfig.Provenance
+		config.Universal = projectConfig.Universal
+	}
+	if licenses && config.Licenses == nil {
+		config.Licenses = &LicensesConfig{}
+	}
+	if sizeReport && config.SizeReport == nil {
+		config.SizeReport = &SizeReportConfig{}
+	}
+	if provenance && config.Provenance == nil {
+		config.Provenance = &ProvenanceConfig{}
+	}
+	if (universal || universalReplace) && config.Universal == nil {
+		config.Universal = &UniversalConfig{}
+	}
+	if universalReplace {
+		config.Universal.Replace = true
+	}
+
+	// Load the signing key up front, so that a bad key or password
+	// fails before anything is built
+	if !makeRelease && !listTargets {
+		signConfig := SignConfig{}
+		if config.ProjectConfig != nil && config.ProjectConfig.Sign != nil {
+			signConfig = *config.ProjectConfig.Sign
+			sign = true
+		}
+		if signKey != "" {
+			signConfig.KeyFile = signKey
+			sign = true
+		}
+		if sign {
+			config.Signer, err = newSigner(signConfig, myDir)
+			if err != nil {
+				fail(err.Error())
+			}
+		}
+		if err := checkPackageSigning(&config); err != nil {
+			fail(err.Error())
+		}
+		if err := checkChecksumsSigning(&config); err != nil {
+			fail(err.Error())
+		}
+	}
+	config.Artifacts.Project = config.ProjectName
+
+	// -checks turns on both go vet and go test
+	if runChecksFlag {
+		checks := ChecksConfig{}
+		if config.Checks != nil {
+			checks = *config.Checks
+		}
+		checks.Vet = true
+		checks.Test = true
+		config.Checks = &checks
 	}
 	// parse additional build args
 	if buildArgs != "" {
@@ -257,9 +419,15 @@ flag.Usage = func() {
 		os.Exit(0)
 	}
 
+	// Ctrl-C or SIGTERM cancels the running go build/gh command, the
+	// partial artifacts are removed and what finished is reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// ./bin must have the archives for release
 	if makeRelease {
-		err = createRelease(&config, releaseNote, releaseNoteFile)
+		err = createRelease(ctx, &config, releaseNote, releaseNoteFile)
+		checkInterrupted(ctx, &config)
 		if err != nil {
 			fail(err.Error())
 		}
@@ -269,10 +437,16 @@ flag.Usage = func() {
 	// Otherwise, run the main process
 	if config.ProjectConfig != nil {
 		fmt.Printf("Building multi-target project: %s\n", config.ProjectName)
-		err = processMultiTarget(&config)
+		err = processMultiTarget(ctx, &config)
 	} else {
 		fmt.Printf("Building single-target project: %s\n", config.ProjectName)
-		err = process(&config)
+		err = process(ctx, &config)
+	}
+	checkInterrupted(ctx, &config)
+
+	if !config.DryRun {
+		fmt.Println()
+		config.Summary.print()
 	}
 	
 	if err != nil {
@@ -280,6 +454,16 @@ flag.Usage = func() {
 	}
 }
 
+// If the run was interrupted, print what finished and exit
+func checkInterrupted(ctx context.Context, config *Config) {
+	if ctx.Err() == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\nInterrupted\n")
+	config.Summary.print()
+	fail("interrupted, partial artifacts were removed")
+}
+
 // Load project configuration from JSON file
 func loadProjectConfig(configPath, baseDir string) (*ProjectConfig, error) {
 	// Make path absolute if it's relative
@@ -311,11 +495,61 @@ func loadProjectConfig(configPath, baseDir string) (*ProjectConfig, error) {
 		}
 	}
 
+	if err := checkProjectEnv(&config); err != nil {
+		return nil, err
+	}
+
+	if err := checkHooks("project", config.Hooks); err != nil {
+		return nil, err
+	}
+
+	if err := checkLicensesConfig(config.Licenses); err != nil {
+		return nil, err
+	}
+
+	if err := checkSignConfig(config.Sign); err != nil {
+		return nil, err
+	}
+
+	if err := checkChecksumsConfig(config.Checksums); err != nil {
+		return nil, err
+	}
+	for _, target := range config.Targets {
+		if err := checkHooks("target "+target.Name, target.Hooks); err != nil {
+			return nil, err
+		}
+		if err := checkSmokeTest("target "+target.Name, target.SmokeTest); err != nil {
+			return nil, err
+		}
+		if err := checkPackagesConfig("target "+target.Name, mergePackagesConfig(config.Packages, target.Packages)); err != nil {
+			return nil, err
+		}
+		winget := config.Winget
+		if target.Winget != nil {
+			winget = target.Winget
+		}
+		if err := checkWingetConfig("target "+target.Name, winget, mergePackagesConfig(config.Packages, target.Packages)); err != nil {
+			return nil, err
+		}
+		oci := config.OCI
+		if target.OCI != nil {
+			oci = target.OCI
+		}
+		if err := checkOCIConfig("target "+target.Name, oci); err != nil {
+			return nil, err
+		}
+		if target.MaxSize != "" {
+			if _, err := parseSize(target.MaxSize); err != nil {
+				return nil, fmt.Errorf("target %s: max_size: %v", target.Name, err)
+			}
+		}
+	}
+
 	return &config, nil
 }
 
 // Process multi-target builds
-func processMultiTarget(config *Config) error {
+func processMultiTarget(ctx context.Context, config *Config) error {
 	// Initialize
 	if err := initialize(config); err != nil {
 		return err
@@ -331,68 +565,135 @@ func processMultiTarget(config *Config) error {
 	fmt.Printf("Building %s version %s with %d targets\n", config.ProjectName, version, len(projectConfig.Targets))
 	fmt.Printf("The binaries are cross compiled with %s\n", url)
 
+	// Sizes of the previous build for the size report
+	previous, err := loadArtifacts(config.BinDir)
+	if err != nil {
+		return err
+	}
+
+	projectData := newTemplateData(ctx, config, version, platform{})
+	if err := runHooks(ctx, config, projectConfig.Hooks, "before", projectData); err != nil {
+		return err
+	}
+
+	if err := runChecks(ctx, config, version); err != nil {
+		return err
+	}
+
+	// Clean existing project checksums
+	if err := removeChecksums(config, version); err != nil {
+		return err
+	}
+
 	// Build each target
 	for _, target := range projectConfig.Targets {
-		fmt.Printf("\n=== Building target: %s ===\n", target.Name)
-		
-		// Create target-specific config
-		targetConfig := *config
-		targetConfig.ProjectName = target.Name
-		if target.OutputName != "" {
-			targetConfig.ProjectName = target.OutputName
+		// Don't start another target if we were interrupted
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		// Set target-specific build parameters
-		targetConfig.LdFlags = projectConfig.DefaultLdFlags
-		if target.LdFlags != "" {
-			targetConfig.LdFlags = target.LdFlags
-		}
+		fmt.Printf("\n=== Building target: %s ===\n", target.Name)
 		
-		targetConfig.BuildFlags = projectConfig.DefaultBuildFlags
-		if target.BuildFlags != "" {
-			targetConfig.BuildFlags = target.BuildFlags
-		}
-
-		// Combine global and target-specific additional files
-		targetConfig.AdditionalFiles = append(projectConfig.GlobalAdditionalFiles, target.AdditionalFiles...)
-		targetConfig.AdditionalFiles = append(targetConfig.AdditionalFiles, config.AdditionalFiles...) // Add CLI files
+		targetConfig := newTargetConfig(config, target)
 
 		// Clean existing checksums for this target
-		checksumFile := filepath.Join(config.BinDir, fmt.Sprintf("%s-%s-%s", targetConfig.ProjectName, version, config.ChecksumsFile))
-		if err := os.RemoveAll(checksumFile); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to remove old checksums file: %v", err)
+		if err := removeChecksums(targetConfig, version); err != nil {
+			return err
+		}
+
+		targetData := newTemplateData(ctx, targetConfig, version, platform{})
+		if err := runHooks(ctx, targetConfig, target.Hooks, "before", targetData); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
 		}
 
 		// Build for platforms in platforms.txt
-		if err := buildForPlatformsWithPath(&targetConfig, version, target.Path); err != nil {
+		if err := buildForPlatformsWithPath(ctx, targetConfig, version, target.Path); err != nil {
 			return fmt.Errorf("failed to build target %s: %v", target.Name, err)
 		}
 
 		if buildForPi {
 			// Build for Raspberry Pi variants
-			if err := buildPiWithPath(&targetConfig, version, target.Path, "", "7"); err != nil {
+			if err := buildPiWithPath(ctx, targetConfig, version, target.Path, "", "7"); err != nil {
 				return fmt.Errorf("failed to build target %s for Pi: %v", target.Name, err)
 			}
-			if err := buildPiWithPath(&targetConfig, version, target.Path, "-jessie", "6"); err != nil {
+			if err := buildPiWithPath(ctx, targetConfig, version, target.Path, "-jessie", "6"); err != nil {
 				return fmt.Errorf("failed to build target %s for Pi Jessie: %v", target.Name, err)
 			}
 		}
 
+		if err := buildUniversal(ctx, targetConfig, version); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+		if err := buildImages(ctx, targetConfig, version); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+
+		if err := signTarget(ctx, targetConfig, version); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+
+		if err := runHooks(ctx, targetConfig, target.Hooks, "after", targetData); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+
 		fmt.Printf("Target %s build complete\n", target.Name)
 	}
 
+	if err := writeProjectChecksums(config, version); err != nil {
+		return err
+	}
+	if projectChecksums(config) {
+		if err := signFiles(ctx, config, checksumsFiles(config, version)); err != nil {
+			return err
+		}
+	}
+	if err := writeManifests(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeProvenance(ctx, config, version); err != nil {
+		return err
+	}
+
+	if err := runHooks(ctx, config, projectConfig.Hooks, "after", projectData); err != nil {
+		return err
+	}
+
+	if err := saveArtifacts(config, version); err != nil {
+		return err
+	}
+	printSizeReport(config, previous)
+
 	fmt.Printf("\nAll targets build complete. Artifacts are in %s\n", config.BinDir)
 	return nil
 }
 
 // Build for platforms with custom path
-func buildForPlatformsWithPath(config *Config, version, buildPath string) error {
+func buildForPlatformsWithPath(ctx context.Context, config *Config, version, buildPath string) error {
+	platforms, err := readPlatforms(config)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range platforms {
+		fmt.Printf("\n> Building for %s\n", p.Label)
+
+		if err := buildPlatform(ctx, config, version, buildPath, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read the platforms from platforms.txt
+func readPlatforms(config *Config) ([]platform, error) {
 	file, err := os.Open(config.PlatformsFile)
 	if err != nil {
-		return fmt.Errorf("failed to open platforms file: %v", err)
+		return nil, fmt.Errorf("failed to open platforms file: %v", err)
 	}
 	defer file.Close()
 
+	var platforms []platform
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -408,83 +709,336 @@ func buildForPlatformsWithPath(config *Config, version, buildPath string) error
 		goos := parts[0]
 		goarch := parts[1]
 
-		fmt.Printf("\n> Building for %s/%s\n", goos, goarch)
+		platforms = append(platforms, platform{
+			GOOS:   goos,
+			GOARCH: goarch,
+			Name:   goos + "-" + goarch,
+			Label:  goos + "/" + goarch,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read platforms file: %v", err)
+	}
+
+	return platforms, nil
+}
+
+// Get the platform of a Raspberry Pi variant
+func piPlatform(variant, armVersion string) platform {
+	return platform{
+		GOOS:   "linux",
+		GOARCH: "arm",
+		GOARM:  armVersion,
+		Name:   "raspberry-pi" + variant,
+		Label:  "raspberry pi" + variant,
+	}
+}
+
+// Build for Raspberry Pi with custom path
+func buildPiWithPath(ctx context.Context, config *Config, version, buildPath, variant, armVersion string) error {
+	p := piPlatform(variant, armVersion)
+
+	fmt.Printf("\n> Building for raspberry pi%s (arm%s)\n", variant, armVersion)
+
+	return buildPlatform(ctx, config, version, buildPath, p)
+}
+
+// platform is a single GOOS/GOARCH (and GOARM) combination to build for
+type platform struct {
+	GOOS   string
+	GOARCH string
+	GOARM  string
+	Name   string // used in file names, e.g. "linux-amd64", "raspberry-pi-jessie"
+	Label  string // used in messages, e.g. "linux/amd64", "raspberry pi-jessie"
+}
+
+// env returns the environment variables selecting the platform for go build
+func (p platform) env() []string {
+	env := []string{
+		"GOOS=" + p.GOOS,
+		"GOARCH=" + p.GOARCH,
+	}
+	if p.GOARM != "" {
+		env = append(env, "GOARM="+p.GOARM)
+	}
+	return env
+}
+
+// buildPlatform builds the binary for one platform, copies the files to
+// the dist directory, creates the archive and takes its checksum. If any
+// step fails or the build is interrupted, the partial binary, dist
+// directory and archive are removed so that only finished artifacts are
+// left behind.
+func buildPlatform(ctx context.Context, config *Config, version, buildPath string, p platform) (err error) {
+	distDir := fmt.Sprintf("%s-%s-%s.d", config.ProjectName, version, p.Name)
+	binaryName := fmt.Sprintf("%s-%s-%s", config.ProjectName, version, p.Name)
+	if p.GOOS == "windows" {
+		binaryName += ".exe"
+	}
 
-		distDir := fmt.Sprintf("%s-%s-%s-%s.d", config.ProjectName, version, goos, goarch)
-		binaryName := fmt.Sprintf("%s-%s-%s-%s", config.ProjectName, version, goos, goarch)
-		if goos == "windows" {
-			binaryName += ".exe"
+	var packages []string
+	defer func() {
+		if err != nil {
+			removePartial(config, version, binaryName, distDir, packages)
 		}
+	}()
 
-		// Set environment variables
-		env := []string{
-			"GOOS=" + goos,
-			"GOARCH=" + goarch,
+	data := newTemplateData(ctx, config, version, p)
+	env, err := buildEnv(config, p, data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.Label, err)
+	}
+
+	if err := runPlatformHooks(ctx, config, "before_each", data); err != nil {
+		return fmt.Errorf("%s: %v", p.Label, err)
+	}
+
+	// Resources of windows binaries, only there while go build runs
+	syso, err := writeWindowsResources(config, version, buildPath, binaryName, p, data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.Label, err)
+	}
+	if syso != "" {
+		defer os.Remove(syso)
+	}
+
+	if config.DryRun {
+		if err := printPlan(config, binaryName, buildPath, env); err != nil {
+			return err
 		}
+		data.Binary = absBinary(binaryName)
+		if err := runPlatformHooks(ctx, config, "after_each", data); err != nil {
+			return err
+		}
+		return smokeTest(ctx, config, binaryName, p)
+	}
 
-		// Build binary with custom path
-		if err := gobuildWithPath(config, binaryName, buildPath, env); err != nil {
-			return fmt.Errorf("failed to build for %s/%s: %v", goos, goarch, err)
+	// Build binary with custom path
+	if err := gobuildWithPath(ctx, config, binaryName, buildPath, env); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		return fmt.Errorf("failed to build for %s: %v", p.Label, err)
+	}
+
+	// The hooks can post-process the binary before it is copied
+	data.Binary = absBinary(binaryName)
+	if err := runPlatformHooks(ctx, config, "after_each", data); err != nil {
+		return fmt.Errorf("%s: %v", p.Label, err)
+	}
 
-		// Copy files
-		if err := copyFiles(config, binaryName, distDir); err != nil {
+	// Make sure we built what was asked for
+	if config.Inspect {
+		if err := inspectPlatformBinary(config, binaryName, p); err != nil {
 			return err
 		}
+	}
+
+	// Make sure it runs, where it can run
+	if err := smokeTest(ctx, config, binaryName, p); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	binarySize, err := checkBinarySize(config, binaryName)
+	if err != nil {
+		return err
+	}
+	printPackageSizes(config, binaryName)
 
-		// Create archive
-		if err := createArchive(config, version, distDir, goos); err != nil {
+	// SBOMs go to the bin directory, their checksums are taken after the
+	// archive's
+	var sboms []string
+	if config.SBOM {
+		if sboms, err = writeSBOMs(config, version, binaryName); err != nil {
 			return err
 		}
+	}
 
-		// Remove binary
-		if err := os.Remove(binaryName); err != nil {
-			return fmt.Errorf("failed to remove binary: %v", err)
+	// Copy files
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := copyFiles(ctx, config, binaryName, distDir); err != nil {
+		return err
+	}
+
+	// Create archive
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	archiveName, err := createArchive(config, version, distDir, p.GOOS)
+	if err != nil {
+		return err
+	}
+	if err := removeSignatures(filepath.Join(config.BinDir, archiveName)); err != nil {
+		return err
+	}
+	archiveInfo, err := os.Stat(filepath.Join(config.BinDir, archiveName))
+	if err != nil {
+		return err
+	}
+	for _, name := range sboms {
+		if err := takeChecksum(config, version, name); err != nil {
+			return fmt.Errorf("failed to take checksum: %v", err)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read platforms file: %v", err)
+	// Linux packages
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if packages, err = buildPackages(ctx, config, version, binaryName, p, data); err != nil {
+		return fmt.Errorf("%s: %v", p.Label, err)
+	}
+
+	// Remove binary
+	if err := os.Remove(binaryName); err != nil {
+		return fmt.Errorf("failed to remove binary: %v", err)
 	}
 
+	buildArgs, err := gobuildArgs(config, binaryName, buildPath)
+	if err != nil {
+		return err
+	}
+
+	config.Summary.add("archive", archiveName)
+	for _, name := range packages {
+		config.Summary.add("package", name)
+	}
+	config.Artifacts.add(artifact{
+		Target:      config.ProjectName,
+		Platform:    p.Name,
+		GOOS:        p.GOOS,
+		GOARCH:      p.GOARCH,
+		GOARM:       p.GOARM,
+		Binary:      binaryName,
+		BinarySize:  binarySize,
+		Archive:     archiveName,
+		ArchiveSize: archiveInfo.Size(),
+		SBOMs:       sboms,
+		Packages:    packages,
+		BuildCommand: append([]string{"go"}, buildArgs...),
+		BuildEnv:     env,
+	})
 	return nil
 }
 
-// Build for Raspberry Pi with custom path
-func buildPiWithPath(config *Config, version, buildPath, variant, armVersion string) error {
-	distDir := fmt.Sprintf("%s-%s-raspberry-pi%s.d", config.ProjectName, version, variant)
-	binaryName := fmt.Sprintf("%s-%s-raspberry-pi%s", config.ProjectName, version, variant)
+// removePartial removes whatever an unfinished platform build left behind.
+// The archive, SBOMs and packages in the bin directory are only removed if
+// the archive is not recorded as finished, their checksums are dropped with
+// them so that the checksums files only list files that exist.
+func removePartial(config *Config, version, binaryName, distDir string, packages []string) {
+	for _, path := range []string{binaryName, distDir, distDir + ".zip", distDir + ".tar.gz"} {
+		if _, err := os.Stat(path); err == nil {
+			fmt.Printf("Removing partial %s\n", path)
+			os.RemoveAll(path)
+		}
+	}
+	if config.Summary.has("archive", distDir+".zip") || config.Summary.has("archive", distDir+".tar.gz") {
+		return
+	}
+	names := []string{distDir + ".zip", distDir + ".tar.gz", sbomBase(binaryName) + cycloneDXExt, sbomBase(binaryName) + spdxExt}
+	for _, name := range append(names, packages...) {
+		path := filepath.Join(config.BinDir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		fmt.Printf("Removing partial %s\n", path)
+		os.RemoveAll(path)
+		if err := dropChecksums(config, version, name); err != nil {
+			fmt.Printf("Warning: failed to drop checksum of %s: %v\n", name, err)
+		}
+	}
+}
 
-	// Set environment variables
-	env := []string{
-		"GOOS=linux",
-		"GOARCH=arm",
-		"GOARM=" + armVersion,
+// Get the config of a target of a multi-target build: the project config
+// with the target's settings
+func newTargetConfig(config *Config, target BuildTarget) *Config {
+	projectConfig := config.ProjectConfig
+	tc := *config
+	tc.Target = &target
+	tc.Packages = mergePackagesConfig(projectConfig.Packages, target.Packages)
+	tc.PackageNames = packageNames{}
+	if target.Universal != nil {
+		tc.Universal = target.Universal
+	}
+	tc.WindowsResources = projectConfig.WindowsResources
+	if target.WindowsResources != nil {
+		tc.WindowsResources = target.WindowsResources
+	}
+	tc.OCI = projectConfig.OCI
+	if target.OCI != nil {
+		tc.OCI = target.OCI
+	}
+	tc.ProjectName = target.Name
+	if target.OutputName != "" {
+		tc.ProjectName = target.OutputName
 	}
 
-	fmt.Printf("\n> Building for raspberry pi%s (arm%s)\n", variant, armVersion)
+	// Set target-specific build parameters
+	tc.LdFlags = projectConfig.DefaultLdFlags
+	if target.LdFlags != "" {
+		tc.LdFlags = target.LdFlags
+	}
 
-	// Build binary with custom path
-	if err := gobuildWithPath(config, binaryName, buildPath, env); err != nil {
-		return fmt.Errorf("failed to build for raspberry pi: %v", err)
+	tc.BuildFlags = projectConfig.DefaultBuildFlags
+	if target.BuildFlags != "" {
+		tc.BuildFlags = target.BuildFlags
 	}
 
-	// Copy files
-	if err := copyFiles(config, binaryName, distDir); err != nil {
+	// Combine global and target-specific additional files
+	tc.AdditionalFiles = append(projectConfig.GlobalAdditionalFiles, target.AdditionalFiles...)
+	tc.AdditionalFiles = append(tc.AdditionalFiles, config.AdditionalFiles...) // Add CLI files
+	return &tc
+}
+
+// Print the go build command and the environment it would run with
+func printPlan(config *Config, output, buildPath string, env []string) error {
+	args, err := gobuildArgs(config, output, buildPath)
+	if err != nil {
 		return err
 	}
+	fmt.Printf("  command: go %s\n", quoteArgs(args))
+	fmt.Printf("  env:\n")
+	for _, e := range env {
+		fmt.Printf("    %s\n", e)
+	}
+	return nil
+}
 
-	// Create archive
-	if err := createArchive(config, version, distDir, "linux"); err != nil {
+// Join arguments for display, quoting the ones with spaces or quotes
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t'\"") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// new--Sep-14-2025 
+func gobuildWithPath(ctx context.Context, config *Config, output, buildPath string, env []string) error {
+	args, err := gobuildArgs(config, output, buildPath)
+	if err != nil {
 		return err
 	}
 
-	// Remove binary
-	return os.Remove(binaryName)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
 }
 
-// new--Sep-14-2025 
-func gobuildWithPath(config *Config, output, buildPath string, env []string) error {
+// Get the arguments for go build
+func gobuildArgs(config *Config, output, buildPath string) ([]string, error) {
 	args := []string{"build"}
 
 	// Add ldflags if specified
@@ -496,7 +1050,7 @@ func gobuildWithPath(config *Config, output, buildPath string, env []string) err
 	if config.BuildFlags != "" {
 		buildFlagArgs, err := parseArguments(config.BuildFlags)
 		if err != nil {
-			return fmt.Errorf("failed to parse build flags: %v", err)
+			return nil, fmt.Errorf("failed to parse build flags: %v", err)
 		}
 		args = append(args, buildFlagArgs...)
 	}
@@ -514,27 +1068,13 @@ func gobuildWithPath(config *Config, output, buildPath string, env []string) err
 		args = append(args, buildPath)
 	}
 
-	// Debug: Print all arguments
-	fmt.Println("=== DEBUG: Arguments being passed to gh ===")
-	fmt.Printf("Number of args: %d\n", len(args))
-	for i, arg := range args {
-		fmt.Printf("  [%d]: %s\n", i, arg)
-	}
-	fmt.Println("=== END DEBUG ===")
-
-	cmd := exec.Command("go", args...)
-	cmd.Env = append(os.Environ(), env...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+	return args, nil
 }
 // new--Sep-14-2025 
 
 
 // Oct-07-2025 --
-func createRelease(config *Config, note, noteFile string) error {
-	fmt.Println("=== DEBUG: createRelease function started ===")
+func createRelease(ctx context.Context, config *Config, note, noteFile string) error {
 	// Check if GitHub CLI exists
 	if err := checkGhCliExists(); err != nil {
 		return err
@@ -565,6 +1105,11 @@ func createRelease(config *Config, note, noteFile string) error {
 		return fmt.Errorf("bin directory is empty")
 	}
 
+	// Don't publish a build whose go vet/go test failed
+	if err := checkChecksPassed(config, version); err != nil {
+		return err
+	}
+
 	// Get the appropriate gh command
 	ghCmd := getGhCommand()
 
@@ -624,13 +1169,14 @@ func createRelease(config *Config, note, noteFile string) error {
 
 	// Step 1: Create the release without assets
 	fmt.Printf("Creating GitHub release %s (without assets)\n", version)
-	cmd := exec.Command(ghCmd, args...)
+	cmd := exec.CommandContext(ctx, ghCmd, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to create GitHub release: %v", err)
 	}
+	config.Summary.add("release", version)
 
 	// Step 2: Upload assets in batches
 	fmt.Println("Uploading assets to release...")
@@ -641,10 +1187,22 @@ func createRelease(config *Config, note, noteFile string) error {
 			continue
 		}
 		fileName := file.Name()
-		// Only include archives and checksum files
+		// Only include archives, checksum files, SBOMs and signatures
 		if strings.HasSuffix(fileName, ".tar.gz") ||
 		   strings.HasSuffix(fileName, ".zip") ||
-		   strings.HasSuffix(fileName, "-checksums.txt") {
+		   strings.HasSuffix(fileName, ".deb") ||
+		   strings.HasSuffix(fileName, ".rpm") ||
+		   strings.HasSuffix(fileName, ".apk") ||
+		   strings.HasSuffix(fileName, ".pkg.tar.zst") ||
+		   isInstallScript(fileName) ||
+		   strings.HasSuffix(fileName, "-checksums.txt") ||
+		   strings.HasSuffix(fileName, "-checksums.json") ||
+		   strings.HasSuffix(fileName, provenanceExt) ||
+		   isSidecar(fileName) ||
+		   strings.HasSuffix(fileName, cycloneDXExt) ||
+		   strings.HasSuffix(fileName, spdxExt) ||
+		   strings.HasSuffix(fileName, minisigExt) ||
+		   strings.HasSuffix(fileName, sigExt) {
 			assetsToUpload = append(assetsToUpload, filepath.Join(config.BinDir, fileName))
 		}
 	}
@@ -666,17 +1224,20 @@ func createRelease(config *Config, note, noteFile string) error {
 			(len(assetsToUpload)+batchSize-1)/batchSize,
 			len(batch))
 
-		uploadCmd := exec.Command(ghCmd, uploadArgs...)
+		uploadCmd := exec.CommandContext(ctx, ghCmd, uploadArgs...)
 		uploadCmd.Stdout = os.Stdout
 		uploadCmd.Stderr = os.Stderr
 
 		if err := uploadCmd.Run(); err != nil {
 			return fmt.Errorf("failed to upload assets batch: %v", err)
 		}
+		for _, asset := range batch {
+			config.Summary.add("upload", filepath.Base(asset))
+		}
 	}
 
 	// List releases
-	listCmd := exec.Command(ghCmd, "release", "list")
+	listCmd := exec.CommandContext(ctx, ghCmd, "release", "list")
 	listCmd.Stdout = os.Stdout
 	listCmd.Stderr = os.Stderr
 
@@ -842,7 +1403,7 @@ func checkGhCliExists() error {
 }
 
 // Process handles the main build process (legacy single-target mode)
-func process(config *Config) error {
+func process(ctx context.Context, config *Config) error {
 	// Initialize
 	if err := initialize(config); err != nil {
 		return err
@@ -858,27 +1419,68 @@ func process(config *Config) error {
 	fmt.Printf("%s version %s\n", config.ProjectName, version)
 	fmt.Printf("The binaries are cross compiled with %s\n", url)
 
+	if err := runChecks(ctx, config, version); err != nil {
+		return err
+	}
+
+	// Sizes of the previous build for the size report
+	previous, err := loadArtifacts(config.BinDir)
+	if err != nil {
+		return err
+	}
+
 	// Clean existing checksums
-	checksumFile := filepath.Join(config.BinDir, fmt.Sprintf("%s-%s-%s", config.ProjectName, version, config.ChecksumsFile))
-	if err := os.RemoveAll(checksumFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove old checksums file: %v", err)
+	if err := removeChecksums(config, version); err != nil {
+		return err
 	}
 
 	// Build for platforms in platforms.txt
-	if err := buildForPlatforms(config, version); err != nil {
+	if err := buildForPlatforms(ctx, config, version); err != nil {
 		return err
 	}
 
 	if buildForPi {
 		// Build for Raspberry Pi variants
-		if err := buildPi(config, version, "", "7"); err != nil { // modern pi
+		if err := buildPi(ctx, config, version, "", "7"); err != nil { // modern pi
 			return err
 		}
-		if err := buildPi(config, version, "-jessie", "6"); err != nil { // pi jessie
+		if err := buildPi(ctx, config, version, "-jessie", "6"); err != nil { // pi jessie
 			return err
 		}
 	}
 
+	if err := buildUniversal(ctx, config, version); err != nil {
+		return err
+	}
+	if err := buildImages(ctx, config, version); err != nil {
+		return err
+	}
+
+	if err := signTarget(ctx, config, version); err != nil {
+		return err
+	}
+
+	// The same steps as after the targets of a multi-target build
+	if err := writeProjectChecksums(config, version); err != nil {
+		return err
+	}
+	if projectChecksums(config) {
+		if err := signFiles(ctx, config, checksumsFiles(config, version)); err != nil {
+			return err
+		}
+	}
+	if err := writeManifests(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeProvenance(ctx, config, version); err != nil {
+		return err
+	}
+
+	if err := saveArtifacts(config, version); err != nil {
+		return err
+	}
+	printSizeReport(config, previous)
+
 	fmt.Printf("Build complete. Artifacts are in %s\n", config.BinDir)
 	return nil
 }
@@ -895,6 +1497,11 @@ func initialize(config *Config) error {
 		return fmt.Errorf("platforms file not found: %s", config.PlatformsFile)
 	}
 
+	// Nothing is written in dry-run mode
+	if config.DryRun {
+		return nil
+	}
+
 	// Create bin directory if it doesn't exist
 	if err := os.MkdirAll(config.BinDir, 0755); err != nil {
 		return fmt.Errorf("could not create bin directory: %s, error: %v", config.BinDir, err)
@@ -903,6 +1510,30 @@ func initialize(config *Config) error {
 	return nil
 }
 
+// Remove the checksums files and the provenance of the previous build and
+// their signatures (not in dry-run mode)
+func removeChecksums(config *Config, version string) error {
+	if config.DryRun {
+		return nil
+	}
+	// all algorithms, the configuration might have changed
+	var files []string
+	for _, algorithm := range checksumAlgorithms {
+		files = append(files, filepath.Join(config.BinDir, checksumsFileName(config, version, algorithm)))
+	}
+	files = append(files, filepath.Join(config.BinDir, checksumsJSONName(config, version)))
+	files = append(files, filepath.Join(config.BinDir, provenanceFileName(config, version)))
+	for _, checksumFile := range files {
+		if err := os.RemoveAll(checksumFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old checksums file: %v", err)
+		}
+		if err := removeSignatures(checksumFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Get version from VERSION file
 func getVersion(config *Config) (string, error) {
 	content, err := os.ReadFile(config.VersionFile)
@@ -912,53 +1543,154 @@ func getVersion(config *Config) (string, error) {
 	return strings.TrimSpace(string(content)), nil
 }
 
+// buildSummary keeps track of what finished during a run (archives built,
+// assets uploaded) and the results of checks, so that it can be reported
+// at the end of the run, even if it was interrupted
+type buildSummary struct {
+	items []summaryItem
+}
+
+type summaryItem struct {
+	Step   string // e.g. "archive", "release", "upload", "vet"
+	Name   string
+	Result string // empty for finished steps, "ok" or "FAILED" for checks
+}
+
+// Record a finished step
+func (s *buildSummary) add(step, name string) {
+	if s == nil {
+		return
+	}
+	s.items = append(s.items, summaryItem{Step: step, Name: name})
+}
+
+// Record the result of a check, err is nil if it passed
+func (s *buildSummary) addResult(step, name string, err error) {
+	if s == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "FAILED"
+	}
+	s.items = append(s.items, summaryItem{Step: step, Name: name, Result: result})
+}
+
+// Remove a finished step, e.g. an archive that was replaced
+func (s *buildSummary) remove(step, name string) {
+	if s == nil {
+		return
+	}
+	items := s.items[:0]
+	for _, item := range s.items {
+		if item.Step != step || item.Name != name {
+			items = append(items, item)
+		}
+	}
+	s.items = items
+}
+
+// Check if a step is recorded as finished
+func (s *buildSummary) has(step, name string) bool {
+	if s == nil {
+		return false
+	}
+	for _, item := range s.items {
+		if item.Step == step && item.Name == name && item.Result == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of failed checks
+func (s *buildSummary) failures() int {
+	n := 0
+	if s != nil {
+		for _, item := range s.items {
+			if item.Result == "FAILED" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Print the summary
+func (s *buildSummary) print() {
+	if s == nil || len(s.items) == 0 {
+		fmt.Println("Nothing finished")
+		return
+	}
+	fmt.Println("Summary:")
+	for _, item := range s.items {
+		if item.Result != "" {
+			fmt.Printf("  %-8s %-40s %s\n", item.Step, item.Name, item.Result)
+			continue
+		}
+		fmt.Printf("  %-8s %s\n", item.Step, item.Name)
+	}
+}
+
 // Print error and exit
 func fail(msg string) {
 	fmt.Fprintf(os.Stderr, "error: %s\n", msg)
 	os.Exit(1)
 }
 
-// Calculate sha256 checksum and append to checksums file
+// Calculate the checksums of a file in the bin directory and append them to
+// the checksums files (or write its sidecar files)
 func takeChecksum(config *Config, version, archive string) error {
-	checksumFilename := fmt.Sprintf("%s-%s-%s", config.ProjectName, version, config.ChecksumsFile)
-
-	// Change to bin directory to get relative paths
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get current directory: %v", err)
+	// Taken for all targets at the end of the build
+	if projectChecksums(config) {
+		return nil
 	}
 
-	if err := os.Chdir(config.BinDir); err != nil {
-		return fmt.Errorf("failed to change to bin directory: %v", err)
-	}
-	defer os.Chdir(currentDir)
+	algorithms := config.Checksums.algorithms()
+	path := filepath.Join(config.BinDir, archive)
 
-	// Read the file
-	data, err := os.ReadFile(archive)
+	// Stream the file, archives can be large
+	sums, size, err := fileChecksums(path, algorithms)
 	if err != nil {
 		return fmt.Errorf("failed to read archive for checksum: %v", err)
 	}
 
-	// Calculate checksum
-	hash := sha256.Sum256(data)
-	checksum := hex.EncodeToString(hash[:])
-
-	// Append to checksum file
-	f, err := os.OpenFile(checksumFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open checksum file: %v", err)
+	sidecar := config.Checksums != nil && config.Checksums.Sidecar
+	if sidecar {
+		if err := writeSidecars(path, sums); err != nil {
+			return fmt.Errorf("failed to write checksum file: %v", err)
+		}
+	} else {
+		// Remove sidecars of a previous build
+		if err := writeSidecars(path, nil); err != nil {
+			return fmt.Errorf("failed to remove old checksum file: %v", err)
+		}
+		for _, algorithm := range algorithms {
+			checksumFilename := filepath.Join(config.BinDir, checksumsFileName(config, version, algorithm))
+			f, err := os.OpenFile(checksumFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				return fmt.Errorf("failed to open checksum file: %v", err)
+			}
+			_, err = fmt.Fprintf(f, "%s  %s\n", sums[algorithm], archive)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write to checksum file: %v", err)
+			}
+		}
 	}
-	defer f.Close()
 
-	if _, err := fmt.Fprintf(f, "%s  %s\n", checksum, archive); err != nil {
-		return fmt.Errorf("failed to write to checksum file: %v", err)
+	if config.Checksums != nil && config.Checksums.JSON {
+		entry := checksumEntry{Name: archive, Size: size, Checksums: sums}
+		if err := appendChecksumsJSON(config, version, entry); err != nil {
+			return fmt.Errorf("failed to write to checksum file: %v", err)
+		}
 	}
 
 	return nil
 }
 
 // Copy required files to distribution directory
-func copyFiles(config *Config, bin, distDir string) error {
+func copyFiles(ctx context.Context, config *Config, bin, distDir string) error {
 	// Create dist directory
 	if err := os.MkdirAll(distDir, 0755); err != nil {
 		return fmt.Errorf("failed to create dist directory: %v", err)
@@ -986,6 +1718,13 @@ func copyFiles(config *Config, bin, distDir string) error {
 		}
 	}
 
+	// Licenses of the third party modules in the binary
+	if config.Licenses != nil {
+		if err := writeThirdPartyLicenses(ctx, config, bin, distDir); err != nil {
+			return fmt.Errorf("failed to bundle third party licenses: %v", err)
+		}
+	}
+
 	// Copy additional files if specified
 	/*
 	for _, filePath := range config.AdditionalFiles {
@@ -1050,36 +1789,37 @@ func cleanupDir(dir string) error {
 	return nil
 }
 
-// Create archive (zip for windows, tar.gz for others)
-func createArchive(config *Config, version, distDir, goos string) error {
+// Create archive (zip for windows, tar.gz for others). Returns the name
+// of the archive in the bin directory
+func createArchive(config *Config, version, distDir, goos string) (string, error) {
 	var archiveName string
 
 	// Create appropriate archive based on OS
 	if goos == "windows" {
 		archiveName = distDir + ".zip"
 		if err := zipDir(distDir, archiveName); err != nil {
-			return fmt.Errorf("failed to create zip archive: %v", err)
+			return "", fmt.Errorf("failed to create zip archive: %v", err)
 		}
 	} else {
 		archiveName = distDir + ".tar.gz"
 		if err := tarGzDir(distDir, archiveName); err != nil {
-			return fmt.Errorf("failed to create tar archive: %v", err)
+			return "", fmt.Errorf("failed to create tar archive: %v", err)
 		}
 	}
 
 	// Move archive to bin directory
 	finalArchivePath := filepath.Join(config.BinDir, filepath.Base(archiveName))
 	if err := moveFile(archiveName, finalArchivePath); err != nil {
-		return fmt.Errorf("failed to move archive to bin directory: %v", err)
+		return "", fmt.Errorf("failed to move archive to bin directory: %v", err)
 	}
 
 	// Take checksum
 	if err := takeChecksum(config, version, filepath.Base(archiveName)); err != nil {
-		return fmt.Errorf("failed to take checksum: %v", err)
+		return "", fmt.Errorf("failed to take checksum: %v", err)
 	}
 
 	// Cleanup
-	return cleanupDir(distDir)
+	return filepath.Base(archiveName), cleanupDir(distDir)
 }
 
 // Helper function to move a file
@@ -1207,69 +1947,12 @@ func tarGzDir(srcDir, destTarGz string) error {
 }
 
 // Build for Raspberry Pi (legacy single-target mode)
-func buildPi(config *Config, version, variant, armVersion string) error {
-	distDir := fmt.Sprintf("%s-%s-raspberry-pi%s.d", config.ProjectName, version, variant)
-	binaryName := fmt.Sprintf("%s-%s-raspberry-pi%s", config.ProjectName, version, variant)
-
-	// Set environment variables
-	env := []string{
-		"GOOS=linux",
-		"GOARCH=arm",
-		"GOARM=" + armVersion,
-	}
-
-	fmt.Printf("> Building for raspberry pi%s (arm%s)\n", variant, armVersion)
-
-	// Build binary
-	if err := gobuild(config, binaryName, env); err != nil {
-		return fmt.Errorf("failed to build for raspberry pi: %v", err)
-	}
-
-	// Copy files
-	if err := copyFiles(config, binaryName, distDir); err != nil {
-		return err
-	}
-
-	// Create archive
-	if err := createArchive(config, version, distDir, "linux"); err != nil {
-		return err
-	}
-
-	// Remove binary
-	return os.Remove(binaryName)
+func buildPi(ctx context.Context, config *Config, version, variant, armVersion string) error {
+	return buildPiWithPath(ctx, config, version, "", variant, armVersion)
 }
 // new -Sep-14-2025 
-func gobuild(config *Config, output string, env []string) error {
-	args := []string{"build"}
-
-	// Add ldflags if specified
-	if config.LdFlags != "" {
-		args = append(args, "-ldflags="+config.LdFlags)
-	}
-
-	// Parse and add build flags
-	if config.BuildFlags != "" {
-		buildFlagArgs, err := parseArguments(config.BuildFlags)
-		if err != nil {
-			return fmt.Errorf("failed to parse build flags: %v", err)
-		}
-		args = append(args, buildFlagArgs...)
-	}
-
-	// Add extra build args from command line
-	if len(config.ExtraBuildArgs) > 0 {
-		args = append(args, config.ExtraBuildArgs...)
-	}
-
-	// Add output flag
-	args = append(args, "-o", output)
-
-	cmd := exec.Command("go", args...)
-	cmd.Env = append(os.Environ(), env...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+func gobuild(ctx context.Context, config *Config, output string, env []string) error {
+	return gobuildWithPath(ctx, config, output, "", env)
 }
 // new -Sep-14-2025 
 
@@ -1291,68 +1974,8 @@ func gobuildOld(config *Config, output string, env []string) error {
 }
 
 // Build for platforms in platforms.txt (legacy single-target mode)
-func buildForPlatforms(config *Config, version string) error {
-	file, err := os.Open(config.PlatformsFile)
-	if err != nil {
-		return fmt.Errorf("failed to open platforms file: %v", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		parts := strings.Split(line, "/")
-		if len(parts) < 2 {
-			continue
-		}
-
-		goos := parts[0]
-		goarch := parts[1]
-
-		fmt.Printf("> Building for %s/%s\n", goos, goarch)
-
-		distDir := fmt.Sprintf("%s-%s-%s-%s.d", config.ProjectName, version, goos, goarch)
-		binaryName := fmt.Sprintf("%s-%s-%s-%s", config.ProjectName, version, goos, goarch)
-		if goos == "windows" {
-			binaryName += ".exe"
-		}
-
-		// Set environment variables
-		env := []string{
-			"GOOS=" + goos,
-			"GOARCH=" + goarch,
-		}
-
-		// Build binary
-		if err := gobuild(config, binaryName, env); err != nil {
-			return fmt.Errorf("failed to build for %s/%s: %v", goos, goarch, err)
-		}
-
-		// Copy files
-		if err := copyFiles(config, binaryName, distDir); err != nil {
-			return err
-		}
-
-		// Create archive
-		if err := createArchive(config, version, distDir, goos); err != nil {
-			return err
-		}
-
-		// Remove binary
-		if err := os.Remove(binaryName); err != nil {
-			return fmt.Errorf("failed to remove binary: %v", err)
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read platforms file: %v", err)
-	}
-
-	return nil
+func buildForPlatforms(ctx context.Context, config *Config, version string) error {
+	return buildForPlatformsWithPath(ctx, config, version, "")
 }
 
 // parseArguments parses a string of build arguments, respecting quotes
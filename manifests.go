@@ -0,0 +1,277 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Manifests for package managers (Homebrew etc.) that download the
+// release archives. They are written to the bin directory after the
+// checksums and optionally committed to a local clone of the tap or
+// bucket repository. Pushing is left to the user.
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Download URL of release files on GitHub, used if release_url is not set
+const githubReleaseURL = "https://github.com/%s/releases/download/{{.Version}}/{{.File}}"
+
+// Write the manifests configured for the targets
+func writeManifests(ctx context.Context, config *Config, version string) error {
+	if config.ProjectConfig == nil {
+		return nil
+	}
+	if err := writeHomebrew(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeScoop(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeWinget(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeNix(ctx, config, version); err != nil {
+		return err
+	}
+	if err := writeAUR(ctx, config, version); err != nil {
+		return err
+	}
+	return writeInstallScripts(ctx, config, version)
+}
+
+// Artifacts of a target built in this run
+func targetArtifacts(config *Config, target string) []artifact {
+	var artifacts []artifact
+	for _, a := range config.Artifacts.Artifacts {
+		if a.Target == target {
+			artifacts = append(artifacts, a)
+		}
+	}
+	return artifacts
+}
+
+// Find the artifact of a platform, for arm the first one with the GOARM
+// (e.g. linux-arm before raspberry-pi), for darwin the universal one if
+// there is no per-arch archive
+func findArtifact(artifacts []artifact, goos, goarch, goarm string) (artifact, bool) {
+	for _, a := range artifacts {
+		if a.GOOS != goos || a.GOARCH != goarch {
+			continue
+		}
+		if goarch == "arm" && goarm != "" && a.GOARM != goarm && !(a.GOARM == "" && goarm == "7") {
+			continue
+		}
+		return a, true
+	}
+	// a universal binary runs on both macOS architectures
+	if goos == "darwin" && (goarch == "amd64" || goarch == "arm64") {
+		return findArtifact(artifacts, "darwin", "universal", "")
+	}
+	return artifact{}, false
+}
+
+// Output name of a target
+func targetOutputName(target BuildTarget) string {
+	if target.OutputName != "" {
+		return target.OutputName
+	}
+	return target.Name
+}
+
+// Download URL of a release file from the release_url template, GitHub
+// releases of the origin remote by default
+func releaseURL(ctx context.Context, config *Config, version string, a artifact, file string) (string, error) {
+	t := ""
+	if config.ProjectConfig != nil {
+		t = config.ProjectConfig.ReleaseURL
+	}
+	if t == "" {
+		repo := githubRepository(ctx)
+		if repo == "" {
+			return "", fmt.Errorf("release_url is not set and origin is not a GitHub repository")
+		}
+		t = fmt.Sprintf(githubReleaseURL, repo)
+	}
+	url, err := expandTemplate(t, templateData{
+		Version: version,
+		Target:  a.Target,
+		GOOS:    a.GOOS,
+		GOARCH:  a.GOARCH,
+		GOARM:   a.GOARM,
+		Commit:  gitCommit(ctx),
+		Date:    buildStart.Format(time.RFC3339),
+		File:    file,
+	})
+	if err != nil {
+		return "", fmt.Errorf("release_url: %v", err)
+	}
+	return url, nil
+}
+
+// owner/repo of the origin remote if it is on GitHub, empty otherwise
+func githubRepository(ctx context.Context) string {
+	repo := gitRepository(ctx)
+	if !strings.HasPrefix(repo, "git+https://github.com/") {
+		return ""
+	}
+	return strings.TrimPrefix(repo, "git+https://github.com/")
+}
+
+// Name of the release file with the sha256 of an archive: its sidecar or
+// the sha256 checksums file of the target or project. Empty if sha256 is
+// not one of the algorithms.
+func sha256FileName(config *Config, version string, a artifact) string {
+	found := false
+	for _, alg := range config.Checksums.algorithms() {
+		found = found || alg == "sha256"
+	}
+	if !found {
+		return ""
+	}
+	if config.Checksums != nil && config.Checksums.Sidecar {
+		return a.Archive + ".sha256"
+	}
+	c := *config
+	if !projectChecksums(config) {
+		c.ProjectName = a.Target
+	}
+	return checksumsFileName(&c, version, "sha256")
+}
+
+// manifestInfo is the description of a target in a manifest
+type manifestInfo struct {
+	Description string
+	Homepage    string
+	License     string
+}
+
+// Description, homepage and license of a manifest, defaults from the
+// packages section and the origin repository
+func newManifestInfo(ctx context.Context, config *Config, target BuildTarget, name, description, homepage, license string) manifestInfo {
+	pc := mergePackagesConfig(config.ProjectConfig.Packages, target.Packages)
+	if pc == nil {
+		pc = &PackagesConfig{}
+	}
+	info := manifestInfo{Description: description, Homepage: homepage, License: license}
+	if info.Description == "" {
+		info.Description = pc.Summary
+	}
+	if info.Description == "" {
+		info.Description = name
+	}
+	if info.Homepage == "" {
+		info.Homepage = pc.Homepage
+	}
+	if info.Homepage == "" {
+		info.Homepage = strings.TrimPrefix(gitRepository(ctx), "git+")
+	}
+	if info.License == "" {
+		info.License = pc.License
+	}
+	return info
+}
+
+// sha256 of a file in the bin directory from its .sha256 sidecar or the
+// sha256 checksums files, computed if it is in neither
+func releaseSHA256(binDir, name string) (string, error) {
+	if data, err := os.ReadFile(filepath.Join(binDir, name+".sha256")); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(binDir, "*-checksums.txt"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && len(fields[0]) == 64 && strings.TrimPrefix(fields[1], "*") == name {
+				f.Close()
+				return fields[0], nil
+			}
+		}
+		f.Close()
+	}
+	return sha256File(filepath.Join(binDir, name))
+}
+
+// Commit manifests to a local clone of a repository (relative to the
+// project directory) with the templated commit message
+func commitManifests(ctx context.Context, config *Config, version, name, repo, message string, files map[string]string) error {
+	if !filepath.IsAbs(repo) {
+		repo = filepath.Join(filepath.Dir(config.BinDir), repo)
+	}
+	if message == "" {
+		message = "{{.Target}} {{.Version}}"
+	}
+	message, err := expandTemplate(message, templateData{Version: version, Target: name, Commit: gitCommit(ctx)})
+	if err != nil {
+		return err
+	}
+	return commitToRepo(ctx, repo, files, message)
+}
+
+// Copy files (path in the repository to source file) into a local clone
+// of a repository and commit them there. Nothing is committed if the
+// files did not change.
+func commitToRepo(ctx context.Context, repo string, files map[string]string, message string) error {
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		return fmt.Errorf("%s is not a git repository", repo)
+	}
+	var rels []string
+	for rel, src := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(repo, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repo}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s: %v\n%s", args[0], err, out)
+		}
+		return nil
+	}
+	if err := git(append([]string{"add", "--"}, rels...)...); err != nil {
+		return err
+	}
+	if err := git(append([]string{"diff", "--cached", "--quiet", "--"}, rels...)...); err == nil {
+		fmt.Printf("%s unchanged in %s\n", strings.Join(rels, ", "), repo)
+		return nil
+	}
+	if err := git(append([]string{"commit", "-q", "-m", message, "--"}, rels...)...); err != nil {
+		return err
+	}
+	fmt.Printf("Committed %s to %s, push it to publish\n", strings.Join(rels, ", "), repo)
+	return nil
+}
+
+// Write a manifest to the bin directory and add it to the summary
+func writeManifest(config *Config, step, file, content string) error {
+	path := filepath.Join(config.BinDir, file)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Created %s\n", file)
+	config.Summary.add(step, file)
+	return nil
+}
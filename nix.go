@@ -0,0 +1,231 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Nix packages of the prebuilt linux and darwin archives: a
+// derivation <name>.nix for every target, a default.nix and a
+// flake.nix with all of them
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NixConfig configures the Nix derivations. The project level section is
+// the default for all targets, a target's section replaces it. default.nix
+// and flake.nix are written with the project level settings.
+type NixConfig struct {
+	Name          string `json:"name"`           // package name, default output name of the target
+	Description   string `json:"description"`    // default packages summary, for the flake the project name
+	Homepage      string `json:"homepage"`       // default packages homepage or the origin repository
+	License       string `json:"license"`        // SPDX id, default packages license
+	Repo          string `json:"repo"`           // local clone of the repository for the nix files (optional)
+	Directory     string `json:"directory"`      // directory of the nix files in the repository, default the top
+	CommitMessage string `json:"commit_message"` // templated, default "{{.Target}} {{.Version}}"
+	Skip          bool   `json:"skip"`           // no derivation for this target
+}
+
+// Nix systems of the platforms
+var nixSystems = []struct {
+	system              string
+	goos, goarch, goarm string
+}{
+	{"x86_64-linux", "linux", "amd64", ""},
+	{"aarch64-linux", "linux", "arm64", ""},
+	{"armv7l-linux", "linux", "arm", "7"},
+	{"armv6l-linux", "linux", "arm", "6"},
+	{"i686-linux", "linux", "386", ""},
+	{"x86_64-darwin", "darwin", "amd64", ""},
+	{"aarch64-darwin", "darwin", "arm64", ""},
+}
+
+// Write the derivations of the targets, default.nix and flake.nix and
+// commit them to the repository
+func writeNix(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	// default.nix, flake.nix and the repository take the project level
+	// settings, targets may have their own section without one
+	pc := projectConfig.Nix
+	if pc == nil {
+		pc = &NixConfig{}
+	}
+	dir := pc.Directory
+	var names []string
+	systems := make(map[string]bool)
+	files := make(map[string]string)
+	for _, target := range projectConfig.Targets {
+		nc := projectConfig.Nix
+		if target.Nix != nil {
+			nc = target.Nix
+		}
+		if nc == nil || nc.Skip {
+			continue
+		}
+		name := nc.Name
+		if name == "" {
+			name = targetOutputName(target)
+		}
+		file := name + ".nix"
+		if config.DryRun {
+			fmt.Printf("Would write Nix derivation %s\n", file)
+			names = append(names, name)
+			continue
+		}
+		derivation, targetSystems, err := nixDerivation(ctx, config, version, target, nc, name)
+		if err != nil {
+			return fmt.Errorf("target %s: nix: %v", target.Name, err)
+		}
+		if derivation == "" {
+			fmt.Printf("No linux or darwin archives of %s, skipping Nix derivation\n", target.Name)
+			continue
+		}
+		for _, system := range targetSystems {
+			systems[system] = true
+		}
+		names = append(names, name)
+		if err := writeManifest(config, "nix", file, derivation); err != nil {
+			return err
+		}
+		files[filepath.Join(dir, file)] = filepath.Join(config.BinDir, file)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if config.DryRun {
+		fmt.Printf("Would write default.nix and flake.nix\n")
+		return nil
+	}
+
+	var systemList []string
+	for system := range systems {
+		systemList = append(systemList, system)
+	}
+	sort.Strings(systemList)
+	nix := map[string]string{
+		"default.nix": nixDefault(names),
+		"flake.nix":   nixFlake(config, pc, names, systemList),
+	}
+	for _, file := range []string{"default.nix", "flake.nix"} {
+		if err := writeManifest(config, "nix", file, nix[file]); err != nil {
+			return err
+		}
+		files[filepath.Join(dir, file)] = filepath.Join(config.BinDir, file)
+	}
+
+	if pc.Repo == "" {
+		return nil
+	}
+	if err := commitManifests(ctx, config, version, config.ProjectName, pc.Repo, pc.CommitMessage, files); err != nil {
+		return fmt.Errorf("nix: %v", err)
+	}
+	return nil
+}
+
+// Render the derivation of a target and return its systems, empty if the
+// target has no linux or darwin archives
+func nixDerivation(ctx context.Context, config *Config, version string, target BuildTarget, nc *NixConfig, name string) (string, []string, error) {
+	info := newManifestInfo(ctx, config, target, name, nc.Description, nc.Homepage, nc.License)
+	artifacts := targetArtifacts(config, targetOutputName(target))
+
+	var sources strings.Builder
+	var systems []string
+	for _, s := range nixSystems {
+		a, ok := findArtifact(artifacts, s.goos, s.goarch, s.goarm)
+		if !ok {
+			continue
+		}
+		url, err := releaseURL(ctx, config, version, a, a.Archive)
+		if err != nil {
+			return "", nil, err
+		}
+		sum, err := releaseSHA256(config.BinDir, a.Archive)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(&sources, "    %s = {\n", nixString(s.system))
+		fmt.Fprintf(&sources, "      url = %s;\n", nixString(url))
+		fmt.Fprintf(&sources, "      sha256 = %s;\n", nixString(sum))
+		fmt.Fprintf(&sources, "      binary = %s;\n", nixString(a.Binary))
+		fmt.Fprintf(&sources, "    };\n")
+		systems = append(systems, s.system)
+	}
+	if len(systems) == 0 {
+		return "", nil, nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s, do not edit\n", me, versionString())
+	fmt.Fprintf(&b, "{ lib, stdenvNoCC, fetchurl }:\n\n")
+	fmt.Fprintf(&b, "let\n  sources = {\n%s  };\n", sources.String())
+	fmt.Fprintf(&b, "  system = stdenvNoCC.hostPlatform.system;\n")
+	fmt.Fprintf(&b, "  source = sources.${system} or (throw \"%s: unsupported system ${system}\");\n", strings.Trim(nixString(name), `"`))
+	fmt.Fprintf(&b, "in\nstdenvNoCC.mkDerivation {\n")
+	fmt.Fprintf(&b, "  pname = %s;\n", nixString(name))
+	fmt.Fprintf(&b, "  version = %s;\n\n", nixString(strings.TrimPrefix(version, "v")))
+	fmt.Fprintf(&b, "  src = fetchurl {\n    inherit (source) url sha256;\n  };\n\n")
+	fmt.Fprintf(&b, "  dontConfigure = true;\n  dontBuild = true;\n\n")
+	fmt.Fprintf(&b, "  installPhase = ''\n    runHook preInstall\n")
+	fmt.Fprintf(&b, "    install -Dm755 ${source.binary} $out/bin/%s\n", name)
+	fmt.Fprintf(&b, "    runHook postInstall\n  '';\n\n")
+	fmt.Fprintf(&b, "  meta = {\n")
+	fmt.Fprintf(&b, "    description = %s;\n", nixString(info.Description))
+	if info.Homepage != "" {
+		fmt.Fprintf(&b, "    homepage = %s;\n", nixString(info.Homepage))
+	}
+	if info.License != "" {
+		fmt.Fprintf(&b, "    license = lib.getLicenseFromSpdxId %s;\n", nixString(info.License))
+	}
+	fmt.Fprintf(&b, "    platforms = builtins.attrNames sources;\n")
+	fmt.Fprintf(&b, "    mainProgram = %s;\n", nixString(name))
+	fmt.Fprintf(&b, "    sourceProvenance = [ lib.sourceTypes.binaryNativeCode ];\n")
+	fmt.Fprintf(&b, "  };\n}\n")
+	return b.String(), systems, nil
+}
+
+// default.nix with all packages
+func nixDefault(names []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s, do not edit\n", me, versionString())
+	fmt.Fprintf(&b, "{ pkgs ? import <nixpkgs> { } }:\n\n{\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s = pkgs.callPackage ./%s.nix { };\n", nixString(name), name)
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// flake.nix with all packages, the first one is the default
+func nixFlake(config *Config, pc *NixConfig, names, systems []string) string {
+	description := pc.Description
+	if description == "" {
+		description = config.ProjectName
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by %s %s, do not edit\n", me, versionString())
+	fmt.Fprintf(&b, "{\n  description = %s;\n\n", nixString(description))
+	fmt.Fprintf(&b, "  inputs.nixpkgs.url = \"github:NixOS/nixpkgs/nixos-unstable\";\n\n")
+	fmt.Fprintf(&b, "  outputs = { self, nixpkgs }:\n    let\n")
+	quoted := make([]string, len(systems))
+	for i, system := range systems {
+		quoted[i] = nixString(system)
+	}
+	fmt.Fprintf(&b, "      systems = [ %s ];\n", strings.Join(quoted, " "))
+	fmt.Fprintf(&b, "      forAllSystems = nixpkgs.lib.genAttrs systems;\n    in\n    {\n")
+	fmt.Fprintf(&b, "      packages = forAllSystems (system:\n")
+	fmt.Fprintf(&b, "        let\n          pkgs = nixpkgs.legacyPackages.${system};\n        in\n        {\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "          %s = pkgs.callPackage ./%s.nix { };\n", nixString(name), name)
+	}
+	fmt.Fprintf(&b, "          default = self.packages.${system}.%s;\n", nixString(names[0]))
+	fmt.Fprintf(&b, "        });\n    };\n}\n")
+	return b.String()
+}
+
+// Nix string literal
+func nixString(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`).Replace(s)
+	return `"` + s + `"`
+}
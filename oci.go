@@ -0,0 +1,812 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// OCI container images of a target without a container engine: one
+// layer with the linux binary and files on top of scratch or a local
+// base image for every linux GOARCH, and an image index of all of them,
+// written as an OCI layout directory or a docker load tarball
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OCIConfig configures the container image of a target. The project
+// level section is the default for all targets, a target's section
+// replaces it.
+type OCIConfig struct {
+	Name       string            `json:"name"`        // image name, default output name of the target
+	Tags       []string          `json:"tags"`        // templated, default the version
+	Formats    []string          `json:"formats"`     // "oci" layout directory and/or "docker" tarball, default oci
+	Base       string            `json:"base"`        // OCI layout directory or tarball of the base image, default scratch
+	Binary     string            `json:"binary"`      // path of the binary in the image, default /usr/local/bin/<output name>
+	Files      []PackageFile     `json:"files"`       // additional files in the layer
+	Entrypoint []string          `json:"entrypoint"`  // default the binary
+	Cmd        []string          `json:"cmd"`         // arguments of the entrypoint
+	Env        []string          `json:"env"`         // NAME=value, added to the base's
+	User       string            `json:"user"`        // e.g. "65532:65532", default the base's
+	WorkingDir string            `json:"working_dir"` // default the base's
+	Ports      []string          `json:"ports"`       // exposed ports, e.g. "8080" or "53/udp"
+	Labels     map[string]string `json:"labels"`      // templated, added to the base's and the defaults
+	Skip       bool              `json:"skip"`        // no image for this target
+}
+
+// Media types of the OCI image spec and their docker equivalents
+const (
+	ociIndexType       = "application/vnd.oci.image.index.v1+json"
+	ociManifestType    = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigType      = "application/vnd.oci.image.config.v1+json"
+	ociLayerType       = "application/vnd.oci.image.layer.v1.tar+gzip"
+	dockerListType     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Image formats
+var imageFormats = map[string]bool{"oci": true, "docker": true}
+
+// ociDescriptor refers to a blob
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociPlatform is the OS and architecture of an image
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ociIndex is an image index or the index.json of a layout
+type ociIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor   `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest is the manifest of an image of one platform
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociImage is the image configuration. Fields of a base image not listed
+// here are dropped.
+type ociImage struct {
+	Created      string           `json:"created,omitempty"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	OSVersion    string           `json:"os.version,omitempty"`
+	Variant      string           `json:"variant,omitempty"`
+	Config       ociImageRuntime  `json:"config"`
+	RootFS       ociRootFS        `json:"rootfs"`
+	History      []ociHistoryItem `json:"history,omitempty"`
+}
+
+// ociImageRuntime is how a container of the image is run
+type ociImageRuntime struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// ociRootFS lists the uncompressed digests of the layers
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// ociHistoryItem describes how a layer was made
+type ociHistoryItem struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// Check the oci section of a target
+func checkOCIConfig(name string, c *OCIConfig) error {
+	if c == nil || c.Skip {
+		return nil
+	}
+	for _, format := range c.Formats {
+		if !imageFormats[format] {
+			return fmt.Errorf("%s: oci: unknown format %q, use oci or docker", name, format)
+		}
+	}
+	for _, f := range c.Files {
+		if f.Src == "" || f.Dst == "" {
+			return fmt.Errorf("%s: oci: files need src and dst", name)
+		}
+		if !strings.HasPrefix(f.Dst, "/") && !strings.Contains(f.Dst, "{{") {
+			return fmt.Errorf("%s: oci: dst %s must be an absolute path", name, f.Dst)
+		}
+		if f.Mode != "" {
+			if _, err := strconv.ParseUint(f.Mode, 8, 32); err != nil {
+				return fmt.Errorf("%s: oci: invalid mode %q for %s", name, f.Mode, f.Dst)
+			}
+		}
+	}
+	if c.Binary != "" && !strings.HasPrefix(c.Binary, "/") {
+		return fmt.Errorf("%s: oci: binary %s must be an absolute path", name, c.Binary)
+	}
+	for _, env := range c.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("%s: oci: env %q is not NAME=value", name, env)
+		}
+	}
+	for _, port := range c.Ports {
+		if _, err := ociPort(port); err != nil {
+			return fmt.Errorf("%s: oci: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Exposed port with its protocol, e.g. 8080/tcp
+func ociPort(port string) (string, error) {
+	number, protocol, found := strings.Cut(port, "/")
+	if !found {
+		protocol = "tcp"
+	}
+	if n, err := strconv.ParseUint(number, 10, 16); err != nil || n == 0 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid protocol of port %q", port)
+	}
+	return number + "/" + protocol, nil
+}
+
+// Build the images of the linux archives of the target and write the
+// image index, after the platforms of the target are built
+func buildImages(ctx context.Context, config *Config, version string) (err error) {
+	oc := config.OCI
+	if oc == nil || oc.Skip {
+		return nil
+	}
+	name := oc.Name
+	if name == "" {
+		name = strings.ToLower(config.ProjectName)
+	}
+	data := newTemplateData(ctx, config, version, platform{})
+	tags := oc.Tags
+	if len(tags) == 0 {
+		tags = []string{"{{.Version}}"}
+	}
+	var refs []string
+	for _, tag := range tags {
+		t, err := expandTemplate(tag, data)
+		if err != nil {
+			return fmt.Errorf("oci: %v", err)
+		}
+		refs = append(refs, ociTag(t))
+	}
+	formats := make(map[string]bool)
+	for _, format := range oc.Formats {
+		formats[format] = true
+	}
+	if len(formats) == 0 {
+		formats["oci"] = true
+	}
+	layoutName := fmt.Sprintf("%s-%s-oci", config.ProjectName, version)
+	tarName := fmt.Sprintf("%s-%s-docker.tar", config.ProjectName, version)
+
+	fmt.Printf("\n> Building %s container image\n", config.ProjectName)
+	if config.DryRun {
+		if formats["oci"] {
+			fmt.Printf("Would write OCI layout %s\n", layoutName)
+		}
+		if formats["docker"] {
+			fmt.Printf("Would write docker archive %s\n", tarName)
+		}
+		return nil
+	}
+
+	tmp, err := os.MkdirTemp("", "xbuild-oci-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	layout := filepath.Join(tmp, "layout")
+	if formats["oci"] {
+		layout = filepath.Join(config.BinDir, layoutName)
+	}
+	for _, old := range []string{filepath.Join(config.BinDir, layoutName), filepath.Join(config.BinDir, tarName)} {
+		if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(filepath.Join(config.BinDir, layoutName))
+			os.Remove(filepath.Join(config.BinDir, tarName))
+		}
+	}()
+	if err := os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755); err != nil {
+		return err
+	}
+
+	// A tarball of a base layout is extracted first
+	baseDir := ""
+	if oc.Base != "" {
+		baseDir = oc.Base
+		if !filepath.IsAbs(baseDir) {
+			baseDir = filepath.Join(filepath.Dir(config.BinDir), baseDir)
+		}
+		info, err := os.Stat(baseDir)
+		if err != nil {
+			return fmt.Errorf("oci: base image: %v", err)
+		}
+		if !info.IsDir() {
+			f, err := os.Open(baseDir)
+			if err != nil {
+				return fmt.Errorf("oci: base image: %v", err)
+			}
+			baseDir = filepath.Join(tmp, "base")
+			err = extractTar(f, baseDir)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("oci: base image %s: %v", oc.Base, err)
+			}
+		}
+	}
+
+	var images []ociDescriptor
+	var manifests []ociManifest
+	seen := make(map[ociPlatform]bool)
+	for _, a := range targetArtifacts(config, config.ProjectName) {
+		if a.GOOS != "linux" {
+			continue
+		}
+		p := ociPlatform{Architecture: a.GOARCH, OS: a.GOOS}
+		if a.GOARCH == "arm" {
+			// GOARM is 7 by default when cross compiling
+			p.Variant = "v7"
+			if a.GOARM != "" {
+				p.Variant = "v" + a.GOARM
+			}
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		desc, manifest, err := buildImage(ctx, config, version, oc, layout, baseDir, a, p)
+		if err != nil {
+			return fmt.Errorf("oci: %s/%s%s: %v", p.OS, p.Architecture, p.Variant, err)
+		}
+		fmt.Printf("Created image %s/%s%s %s\n", p.OS, p.Architecture, p.Variant, desc.Digest)
+		images = append(images, desc)
+		manifests = append(manifests, manifest)
+	}
+	if len(images) == 0 {
+		fmt.Printf("No linux archives of %s, skipping container image\n", config.ProjectName)
+		return os.RemoveAll(filepath.Join(config.BinDir, layoutName))
+	}
+
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexType,
+		Manifests:     images,
+		Annotations:   map[string]string{"org.opencontainers.image.created": data.Date},
+	})
+	if err != nil {
+		return err
+	}
+	indexDesc, err := writeBlob(layout, ociIndexType, index)
+	if err != nil {
+		return err
+	}
+
+	// index.json has the image index once for every tag
+	top := ociIndex{SchemaVersion: 2, MediaType: ociIndexType}
+	var repoTags []string
+	for _, ref := range refs {
+		d := indexDesc
+		d.Annotations = map[string]string{
+			"org.opencontainers.image.ref.name": ref,
+			"io.containerd.image.name":          name + ":" + ref,
+		}
+		top.Manifests = append(top.Manifests, d)
+		repoTags = append(repoTags, name+":"+ref)
+	}
+	topData, err := json.MarshalIndent(top, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(layout, "index.json"), append(topData, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`+"\n"), 0644); err != nil {
+		return err
+	}
+	if formats["oci"] {
+		fmt.Printf("Created OCI layout %s\n", layoutName)
+		config.Summary.add("image", layoutName)
+	}
+
+	if formats["docker"] {
+		i := dockerPlatform(images)
+		if err := writeDockerArchive(layout, filepath.Join(config.BinDir, tarName), manifests[i], repoTags); err != nil {
+			return fmt.Errorf("oci: %v", err)
+		}
+		p := images[i].Platform
+		fmt.Printf("Created docker archive %s (%s/%s%s with the classic image store)\n", tarName, p.OS, p.Architecture, p.Variant)
+		config.Summary.add("image", tarName)
+	}
+	return nil
+}
+
+// Build the image of a platform, write its blobs to the layout and
+// return the descriptor of its manifest
+func buildImage(ctx context.Context, config *Config, version string, oc *OCIConfig, layout, baseDir string, a artifact, p ociPlatform) (ociDescriptor, ociManifest, error) {
+	data := newTemplateData(ctx, config, version, platform{GOOS: a.GOOS, GOARCH: a.GOARCH, GOARM: a.GOARM})
+	bin, err := readTarGzFile(filepath.Join(config.BinDir, a.Archive), a.Binary)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, fmt.Errorf("%s: %v", a.Archive, err)
+	}
+	binPath := oc.Binary
+	if binPath == "" {
+		binPath = "/usr/local/bin/" + config.ProjectName
+	}
+	files := []packageFile{{Dst: path.Clean(binPath), Mode: 0755, Data: bin}}
+	projectDir := filepath.Dir(config.BinDir)
+	for _, f := range oc.Files {
+		src, err := expandTemplate(f.Src, data)
+		if err != nil {
+			return ociDescriptor{}, ociManifest{}, err
+		}
+		dst, err := expandTemplate(f.Dst, data)
+		if err != nil {
+			return ociDescriptor{}, ociManifest{}, err
+		}
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(projectDir, src)
+		}
+		fileData, err := os.ReadFile(src)
+		if err != nil {
+			return ociDescriptor{}, ociManifest{}, err
+		}
+		mode := int64(0644)
+		if f.Mode != "" {
+			m, _ := strconv.ParseUint(f.Mode, 8, 32)
+			mode = int64(m)
+		}
+		files = append(files, packageFile{Dst: path.Clean(dst), Mode: mode, Data: fileData})
+	}
+	layer, diffID, err := ociLayer(files)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+
+	// The base image's layers and configuration, or scratch
+	var image ociImage
+	var layers []ociDescriptor
+	if baseDir != "" {
+		base, baseConfig, err := ociBaseImage(baseDir, p)
+		if err != nil {
+			return ociDescriptor{}, ociManifest{}, err
+		}
+		if err := json.Unmarshal(baseConfig, &image); err != nil {
+			return ociDescriptor{}, ociManifest{}, fmt.Errorf("base image config: %v", err)
+		}
+		for _, l := range base.Layers {
+			if err := copyBlob(baseDir, layout, l.Digest); err != nil {
+				return ociDescriptor{}, ociManifest{}, fmt.Errorf("base image: %v", err)
+			}
+		}
+		layers = base.Layers
+	}
+	created := buildStart.UTC().Format(time.RFC3339)
+	image.Created = created
+	image.Architecture = p.Architecture
+	image.OS = p.OS
+	image.Variant = p.Variant
+
+	rc := &image.Config
+	rc.Entrypoint = []string{path.Clean(binPath)}
+	if len(oc.Entrypoint) > 0 {
+		rc.Entrypoint = oc.Entrypoint
+	}
+	rc.Cmd = oc.Cmd
+	for _, env := range oc.Env {
+		key := strings.SplitN(env, "=", 2)[0] + "="
+		kept := rc.Env[:0]
+		for _, e := range rc.Env {
+			if !strings.HasPrefix(e, key) {
+				kept = append(kept, e)
+			}
+		}
+		rc.Env = append(kept, env)
+	}
+	if oc.User != "" {
+		rc.User = oc.User
+	}
+	if oc.WorkingDir != "" {
+		rc.WorkingDir = oc.WorkingDir
+	}
+	for _, port := range oc.Ports {
+		if rc.ExposedPorts == nil {
+			rc.ExposedPorts = make(map[string]struct{})
+		}
+		exposed, _ := ociPort(port)
+		rc.ExposedPorts[exposed] = struct{}{}
+	}
+	labels := map[string]string{
+		"org.opencontainers.image.title":   config.ProjectName,
+		"org.opencontainers.image.version": version,
+		"org.opencontainers.image.created": data.Date,
+	}
+	if data.Commit != "" {
+		labels["org.opencontainers.image.revision"] = data.Commit
+	}
+	if repo := githubRepository(ctx); repo != "" {
+		labels["org.opencontainers.image.source"] = "https://github.com/" + repo
+	}
+	for key, value := range oc.Labels {
+		v, err := expandTemplate(value, data)
+		if err != nil {
+			return ociDescriptor{}, ociManifest{}, fmt.Errorf("label %s: %v", key, err)
+		}
+		labels[key] = v
+	}
+	if rc.Labels == nil {
+		rc.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		rc.Labels[key] = value
+	}
+
+	// the history must have an entry for every layer if the base has one
+	image.RootFS.Type = "layers"
+	image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, diffID)
+	if len(image.History) > 0 || len(layers) == 0 {
+		image.History = append(image.History, ociHistoryItem{
+			Created:   created,
+			CreatedBy: me + " " + versionString(),
+			Comment:   a.Binary,
+		})
+	}
+
+	configData, err := json.Marshal(image)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+	configDesc, err := writeBlob(layout, ociConfigType, configData)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+	layerDesc, err := writeBlob(layout, ociLayerType, layer)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		Config:        configDesc,
+		Layers:        append(append([]ociDescriptor(nil), layers...), layerDesc),
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+	desc, err := writeBlob(layout, ociManifestType, manifestData)
+	if err != nil {
+		return ociDescriptor{}, ociManifest{}, err
+	}
+	desc.Platform = &p
+	return desc, manifest, nil
+}
+
+// The gzipped layer tar of the files with their parent directories and
+// the digest of the uncompressed tar
+func ociLayer(files []packageFile) ([]byte, string, error) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Dst < files[j].Dst })
+	dirs := make(map[string]bool)
+	for i, f := range files {
+		if i > 0 && f.Dst == files[i-1].Dst {
+			return nil, "", fmt.Errorf("%s is in the image twice", f.Dst)
+		}
+		for dir := path.Dir(f.Dst); dir != "/"; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var dirList []string
+	for dir := range dirs {
+		dirList = append(dirList, dir)
+	}
+	sort.Strings(dirList)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, dir := range dirList {
+		if err := tw.WriteHeader(rootTarHeader(strings.TrimPrefix(dir, "/")+"/", tar.TypeDir, 0755, 0)); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(rootTarHeader(strings.TrimPrefix(f.Dst, "/"), tar.TypeReg, f.Mode, int64(len(f.Data)))); err != nil {
+			return nil, "", err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	diffID := fmt.Sprintf("sha256:%x", sha256.Sum256(buf.Bytes()))
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return gz.Bytes(), diffID, nil
+}
+
+// The manifest and configuration of the platform in a base image layout
+func ociBaseImage(dir string, p ociPlatform) (ociManifest, []byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return ociManifest{}, nil, fmt.Errorf("base image is not an OCI layout: %v", err)
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ociManifest{}, nil, fmt.Errorf("base image index.json: %v", err)
+	}
+	for _, d := range index.Manifests {
+		m, config, err := ociFindManifest(dir, d, p)
+		if err != nil {
+			return ociManifest{}, nil, err
+		}
+		if m != nil {
+			return *m, config, nil
+		}
+	}
+	return ociManifest{}, nil, fmt.Errorf("base image has no %s/%s%s", p.OS, p.Architecture, p.Variant)
+}
+
+// Find the manifest of a platform under a descriptor of a base image, nil
+// if there is none
+func ociFindManifest(dir string, d ociDescriptor, p ociPlatform) (*ociManifest, []byte, error) {
+	if d.Platform != nil && !ociPlatformMatch(*d.Platform, p) {
+		return nil, nil, nil
+	}
+	switch d.MediaType {
+	case ociIndexType, dockerListType:
+		data, err := readBlob(dir, d.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		var index ociIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", d.Digest, err)
+		}
+		for _, child := range index.Manifests {
+			m, config, err := ociFindManifest(dir, child, p)
+			if err != nil || m != nil {
+				return m, config, err
+			}
+		}
+	case ociManifestType, dockerManifestType:
+		data, err := readBlob(dir, d.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		var m ociManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", d.Digest, err)
+		}
+		config, err := readBlob(dir, m.Config.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		var image ociImage
+		if err := json.Unmarshal(config, &image); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", m.Config.Digest, err)
+		}
+		if ociPlatformMatch(ociPlatform{Architecture: image.Architecture, OS: image.OS, Variant: image.Variant}, p) {
+			return &m, config, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// Whether a base image platform fits an image platform, a missing
+// variant fits any
+func ociPlatformMatch(base, p ociPlatform) bool {
+	if base.OS != p.OS || base.Architecture != p.Architecture {
+		return false
+	}
+	return base.Variant == "" || p.Variant == "" || base.Variant == p.Variant
+}
+
+// Path of a blob in a layout
+func blobPath(dir, digest string) (string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || (algorithm != "sha256" && algorithm != "sha512") {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil || encoded == "" {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(dir, "blobs", algorithm, encoded), nil
+}
+
+// Read a blob of a layout
+func readBlob(dir, digest string) ([]byte, error) {
+	p, err := blobPath(dir, digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// Write a blob to a layout
+func writeBlob(dir, mediaType string, data []byte) (ociDescriptor, error) {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	p, err := blobPath(dir, digest)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}, nil
+}
+
+// Copy a blob of the base image to the layout
+func copyBlob(baseDir, dir, digest string) error {
+	src, err := blobPath(baseDir, digest)
+	if err != nil {
+		return err
+	}
+	dst, err := blobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// Tag of an image, characters docker doesn't allow become "-"
+func ociTag(tag string) string {
+	b := []byte(tag)
+	for i, c := range b {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' && i > 0 || c == '-' && i > 0
+		if !ok {
+			b[i] = '-'
+			if i == 0 {
+				b[i] = '_'
+			}
+		}
+	}
+	if len(b) > 128 {
+		b = b[:128]
+	}
+	return string(b)
+}
+
+// Index of the image for the classic docker image store, which loads a
+// single platform: linux/amd64, else the host's architecture, else the
+// first image
+func dockerPlatform(images []ociDescriptor) int {
+	for _, arch := range []string{"amd64", runtime.GOARCH} {
+		for i, d := range images {
+			if d.Platform != nil && d.Platform.Architecture == arch {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// Write the layout as a tarball docker load reads: the OCI layout for
+// docker with the containerd image store and manifest.json with one
+// platform for the classic one
+func writeDockerArchive(layout, archive string, classic ociManifest, repoTags []string) error {
+	relBlob := func(digest string) string {
+		p, _ := blobPath("", digest)
+		return filepath.ToSlash(p)
+	}
+	entry := struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}{Config: relBlob(classic.Config.Digest), RepoTags: repoTags}
+	for _, l := range classic.Layers {
+		entry.Layers = append(entry.Layers, relBlob(l.Digest))
+	}
+	manifest, err := json.Marshal([]interface{}{entry})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	err = filepath.WalkDir(layout, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == layout {
+			return err
+		}
+		rel, err := filepath.Rel(layout, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			return tw.WriteHeader(rootTarHeader(rel+"/", tar.TypeDir, 0755, 0))
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(rootTarHeader(rel, tar.TypeReg, 0644, info.Size())); err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(rootTarHeader("manifest.json", tar.TypeReg, 0644, int64(len(manifest)))); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,431 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Linux packages of the built binaries. The package metadata and the
+// install layout are configured once in the "packages" section and
+// shared by all package formats
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported package formats
+var packageFormats = []string{"deb", "rpm", "apk", "archlinux"}
+
+// PackagesConfig configures the linux packages of a target. The project
+// level section is the default for all targets, fields set in a target
+// override it.
+type PackagesConfig struct {
+	Formats     []string         `json:"formats"`    // e.g. ["deb", "rpm", "apk", "archlinux"]
+	Name        string           `json:"name"`       // package name, default output name of the target
+	Release     string           `json:"release"`    // package release, default "1"
+	Maintainer  string           `json:"maintainer"` // e.g. "Jane Doe <jane@example.com>"
+	Vendor      string           `json:"vendor"`
+	Summary     string           `json:"summary"`     // one line, default package name
+	Description string           `json:"description"` // long description
+	Homepage    string           `json:"homepage"`
+	License     string           `json:"license"`   // e.g. "MIT"
+	Section     string           `json:"section"`   // deb, default "utils"
+	Depends     []string         `json:"depends"`   // e.g. ["libc6 (>= 2.31)"]
+	BinDir      string           `json:"bindir"`    // where the binary is installed, default /usr/bin
+	Files       []PackageFile    `json:"files"`     // additional files
+	Scripts     PackageScripts   `json:"scripts"`   // maintainer scripts
+	RPM         *RPMConfig       `json:"rpm"`       // rpm specific settings
+	APK         *APKConfig       `json:"apk"`       // apk specific settings
+	ArchLinux   *ArchLinuxConfig `json:"archlinux"` // Arch Linux specific settings
+}
+
+// PackageFile maps a file of the project to its installed path
+type PackageFile struct {
+	Src    string `json:"src"`    // relative to the project directory, templated
+	Dst    string `json:"dst"`    // absolute install path, templated
+	Mode   string `json:"mode"`   // octal, default "0644"
+	Config bool   `json:"config"` // configuration file, kept on upgrade (deb conffiles, rpm %config, pacman backup)
+}
+
+// PackageScripts are shell scripts run by the package manager
+type PackageScripts struct {
+	PreInstall  string `json:"preinstall"`  // deb preinst, rpm %pre, apk .pre-install, pacman pre_install
+	PostInstall string `json:"postinstall"` // deb postinst, rpm %post, apk .post-install, pacman post_install
+	PreRemove   string `json:"preremove"`   // deb prerm, rpm %preun, apk .pre-deinstall, pacman pre_remove
+	PostRemove  string `json:"postremove"`  // deb postrm, rpm %postun, apk .post-deinstall, pacman post_remove
+}
+
+// packageFile is a file with its content as installed by a package
+type packageFile struct {
+	Dst    string // absolute path
+	Mode   int64
+	Data   []byte
+	Config bool
+}
+
+// packageContent is what goes into a package of one platform
+type packageContent struct {
+	Config   *PackagesConfig
+	Name     string
+	Version  string // version without the leading v, "~" for pre-releases
+	Upstream string // version without the leading v
+	Release  string
+	Files    []packageFile     // sorted by Dst
+	Scripts  map[string][]byte // "preinstall" etc. to script content
+}
+
+// Merge the project and target packages sections
+func mergePackagesConfig(project, target *PackagesConfig) *PackagesConfig {
+	if project == nil {
+		return target
+	}
+	if target == nil {
+		return project
+	}
+	c := *project
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	if len(target.Formats) > 0 {
+		c.Formats = target.Formats
+	}
+	set(&c.Name, target.Name)
+	set(&c.Release, target.Release)
+	set(&c.Maintainer, target.Maintainer)
+	set(&c.Vendor, target.Vendor)
+	set(&c.Summary, target.Summary)
+	set(&c.Description, target.Description)
+	set(&c.Homepage, target.Homepage)
+	set(&c.License, target.License)
+	set(&c.Section, target.Section)
+	set(&c.BinDir, target.BinDir)
+	if len(target.Depends) > 0 {
+		c.Depends = target.Depends
+	}
+	c.Files = append(append([]PackageFile(nil), project.Files...), target.Files...)
+	set(&c.Scripts.PreInstall, target.Scripts.PreInstall)
+	set(&c.Scripts.PostInstall, target.Scripts.PostInstall)
+	set(&c.Scripts.PreRemove, target.Scripts.PreRemove)
+	set(&c.Scripts.PostRemove, target.Scripts.PostRemove)
+	if target.RPM != nil {
+		c.RPM = target.RPM
+	}
+	if target.APK != nil {
+		c.APK = target.APK
+	}
+	if target.ArchLinux != nil {
+		c.ArchLinux = target.ArchLinux
+	}
+	return &c
+}
+
+// Check a packages section of the config file
+func checkPackagesConfig(name string, c *PackagesConfig) error {
+	if c == nil {
+		return nil
+	}
+	for _, format := range c.Formats {
+		known := false
+		for _, f := range packageFormats {
+			known = known || f == format
+		}
+		if !known {
+			return fmt.Errorf("%s: packages: unknown format %q (use %s)", name, format, strings.Join(packageFormats, ", "))
+		}
+	}
+	for _, format := range c.Formats {
+		if format == "deb" && c.Maintainer == "" {
+			return fmt.Errorf("%s: packages: maintainer is required for deb", name)
+		}
+	}
+	if c.RPM != nil {
+		for _, dep := range c.RPM.Depends {
+			if _, _, _, err := parseRPMDependency(dep); err != nil {
+				return fmt.Errorf("%s: packages: rpm: %v", name, err)
+			}
+		}
+	}
+	var depends []string
+	if c.APK != nil {
+		depends = append(depends, c.APK.Depends...)
+	}
+	if c.ArchLinux != nil {
+		depends = append(depends, c.ArchLinux.Depends...)
+	}
+	for _, dep := range append(depends, c.Depends...) {
+		if _, err := packageDependency(dep); err != nil {
+			return fmt.Errorf("%s: packages: %v", name, err)
+		}
+	}
+	for _, f := range c.Files {
+		if f.Src == "" || f.Dst == "" {
+			return fmt.Errorf("%s: packages: files need src and dst", name)
+		}
+		if !strings.HasPrefix(f.Dst, "/") && !strings.Contains(f.Dst, "{{") {
+			return fmt.Errorf("%s: packages: dst %s must be an absolute path", name, f.Dst)
+		}
+		if f.Mode != "" {
+			if _, err := strconv.ParseUint(f.Mode, 8, 32); err != nil {
+				return fmt.Errorf("%s: packages: invalid mode %q for %s", name, f.Mode, f.Dst)
+			}
+		}
+	}
+	return nil
+}
+
+// Version for packages: without the leading v, "-" is not allowed in
+// upstream versions of most formats, so pre-releases use "~"
+// (v1.2.0-rc1 becomes 1.2.0~rc1, which sorts before 1.2.0)
+func packageVersion(version string) string {
+	v := strings.TrimPrefix(version, "v")
+	return strings.ReplaceAll(v, "-", "~")
+}
+
+// Collect the content of the packages of a platform: the binary, the man
+// page if the project has one, and the configured files
+func newPackageContent(config *Config, version, binaryName string, data templateData) (*packageContent, error) {
+	c := config.Packages
+	name := c.Name
+	if name == "" {
+		name = config.ProjectName
+	}
+	release := c.Release
+	if release == "" {
+		release = "1"
+	}
+	bindir := c.BinDir
+	if bindir == "" {
+		bindir = "/usr/bin"
+	}
+	content := &packageContent{
+		Config:   c,
+		Name:     name,
+		Version:  packageVersion(version),
+		Upstream: strings.TrimPrefix(version, "v"),
+		Release:  release,
+		Scripts:  make(map[string][]byte),
+	}
+
+	projectDir := filepath.Dir(config.BinDir)
+	binary, err := os.ReadFile(binaryName)
+	if err != nil {
+		return nil, err
+	}
+	content.Files = append(content.Files, packageFile{Dst: path.Join(bindir, config.ProjectName), Mode: 0755, Data: binary})
+
+	// man page, gzipped as the distributions expect
+	manPage := filepath.Join(projectDir, "docs", config.ProjectName+".1")
+	if man, err := os.ReadFile(manPage); err == nil {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(man)
+		zw.Close()
+		content.Files = append(content.Files, packageFile{
+			Dst:  "/usr/share/man/man1/" + config.ProjectName + ".1.gz",
+			Mode: 0644,
+			Data: buf.Bytes(),
+		})
+	}
+
+	for _, f := range c.Files {
+		src, err := expandTemplate(f.Src, data)
+		if err != nil {
+			return nil, fmt.Errorf("packages: %v", err)
+		}
+		dst, err := expandTemplate(f.Dst, data)
+		if err != nil {
+			return nil, fmt.Errorf("packages: %v", err)
+		}
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(projectDir, src)
+		}
+		fileData, err := os.ReadFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("packages: %v", err)
+		}
+		mode := int64(0644)
+		if f.Mode != "" {
+			m, _ := strconv.ParseUint(f.Mode, 8, 32)
+			mode = int64(m)
+		}
+		content.Files = append(content.Files, packageFile{Dst: path.Clean(dst), Mode: mode, Data: fileData, Config: f.Config})
+	}
+	sort.Slice(content.Files, func(i, j int) bool { return content.Files[i].Dst < content.Files[j].Dst })
+	for i := 1; i < len(content.Files); i++ {
+		if content.Files[i].Dst == content.Files[i-1].Dst {
+			return nil, fmt.Errorf("packages: %s is installed twice", content.Files[i].Dst)
+		}
+	}
+
+	scripts := map[string]string{
+		"preinstall":  c.Scripts.PreInstall,
+		"postinstall": c.Scripts.PostInstall,
+		"preremove":   c.Scripts.PreRemove,
+		"postremove":  c.Scripts.PostRemove,
+	}
+	for kind, script := range scripts {
+		if script == "" {
+			continue
+		}
+		if !filepath.IsAbs(script) {
+			script = filepath.Join(projectDir, script)
+		}
+		scriptData, err := os.ReadFile(script)
+		if err != nil {
+			return nil, fmt.Errorf("packages: %v", err)
+		}
+		content.Scripts[kind] = scriptData
+	}
+	return content, nil
+}
+
+// Summary and description of a package
+func (c *packageContent) summary() string {
+	if c.Config.Summary != "" {
+		return c.Config.Summary
+	}
+	return c.Name
+}
+
+// Parent directories of the files, sorted, without "/"
+func (c *packageContent) dirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, f := range c.Files {
+		for dir := path.Dir(f.Dst); dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Total size of the files in bytes
+func (c *packageContent) size() int64 {
+	var size int64
+	for _, f := range c.Files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// Build the configured packages of a linux platform in the bin directory,
+// returns their file names. Platforms that map to a package already built
+// for the target (e.g. linux/arm and raspberry-pi) are skipped.
+func buildPackages(ctx context.Context, config *Config, version, binaryName string, p platform, data templateData) ([]string, error) {
+	if config.Packages == nil || len(config.Packages.Formats) == 0 || p.GOOS != "linux" {
+		return nil, nil
+	}
+	content, err := newPackageContent(config, version, binaryName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, format := range config.Packages.Formats {
+		var name string
+		var pkg []byte
+		switch format {
+		case "deb":
+			arch, ok := debArch(p)
+			if !ok {
+				fmt.Printf("No Debian architecture for %s, skipping deb\n", p.Label)
+				continue
+			}
+			name = fmt.Sprintf("%s_%s-%s_%s.deb", content.Name, content.Version, content.Release, arch)
+			if !config.PackageNames.claim(name) {
+				fmt.Printf("%s was already built for this target, skipping for %s\n", name, p.Label)
+				continue
+			}
+			pkg, err = buildDeb(content, arch)
+		case "rpm":
+			arch, ok := rpmArch(p)
+			if !ok {
+				fmt.Printf("No RPM architecture for %s, skipping rpm\n", p.Label)
+				continue
+			}
+			name = fmt.Sprintf("%s-%s-%s.%s.rpm", content.Name, content.Version, content.Release, arch)
+			if !config.PackageNames.claim(name) {
+				fmt.Printf("%s was already built for this target, skipping for %s\n", name, p.Label)
+				continue
+			}
+			sign := content.Config.RPM != nil && content.Config.RPM.Sign
+			pkg, err = buildRPM(ctx, content, arch, config.Signer, sign)
+		case "apk":
+			arch, ok := apkArch(p)
+			if !ok {
+				fmt.Printf("No Alpine architecture for %s, skipping apk\n", p.Label)
+				continue
+			}
+			name = fmt.Sprintf("%s-%s-r%s.%s.apk", content.Name, apkVersion(content.Upstream), content.Release, arch)
+			if !config.PackageNames.claim(name) {
+				fmt.Printf("%s was already built for this target, skipping for %s\n", name, p.Label)
+				continue
+			}
+			pkg, err = buildAPK(content, arch)
+		case "archlinux":
+			arch, ok := archLinuxArch(p)
+			if !ok {
+				fmt.Printf("No Arch Linux architecture for %s, skipping archlinux\n", p.Label)
+				continue
+			}
+			name = fmt.Sprintf("%s-%s-%s-%s.pkg.tar.zst", content.Name, archLinuxVersion(content.Upstream), content.Release, arch)
+			if !config.PackageNames.claim(name) {
+				fmt.Printf("%s was already built for this target, skipping for %s\n", name, p.Label)
+				continue
+			}
+			pkg, err = buildArchLinux(content, arch)
+		}
+		if err != nil {
+			return names, fmt.Errorf("failed to build %s: %v", format, err)
+		}
+		if err := os.WriteFile(filepath.Join(config.BinDir, name), pkg, 0644); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+		if err := takeChecksum(config, version, name); err != nil {
+			return names, fmt.Errorf("failed to take checksum: %v", err)
+		}
+		fmt.Printf("Created package %s\n", name)
+	}
+	return names, nil
+}
+
+// packageNames are the packages built for a target, to skip platforms
+// mapping to the same package
+type packageNames map[string]bool
+
+// Claim a package name, false if it is taken
+func (n packageNames) claim(name string) bool {
+	if n[name] {
+		return false
+	}
+	n[name] = true
+	return true
+}
+
+// Signed rpm headers need the gpg signing backend
+func checkPackageSigning(config *Config) error {
+	if config.ProjectConfig == nil {
+		return nil
+	}
+	for _, target := range config.ProjectConfig.Targets {
+		c := mergePackagesConfig(config.ProjectConfig.Packages, target.Packages)
+		if c == nil || c.RPM == nil || !c.RPM.Sign {
+			continue
+		}
+		if config.Signer == nil || config.Signer.config.Backend != "gpg" {
+			return fmt.Errorf("target %s: signing rpm packages needs \"sign\": {\"backend\": \"gpg\"}", target.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package blake2b
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum512(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// RFC 7693 appendix A
+		{"abc", "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+			"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{"", "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419" +
+			"d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+	}
+	for _, tt := range tests {
+		sum := Sum512([]byte(tt.in))
+		if got := hex.EncodeToString(sum[:]); got != tt.want {
+			t.Errorf("Sum512(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Writes in chunks across the block boundary, digests from Python's hashlib
+func TestWriteChunks(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	tests := []struct {
+		n, size int
+		want    string
+	}{
+		{1000, Size, "c11e1c0340bd7e5a1b275f1230c962fad215ecb1391486e74e31b960a2f29963" +
+			"81a5fad092da06841d5f26e38f6ecfeaf441acbcd1c2de61aef121e7927175f5"},
+		{1000, Size256, "b372d0608f720c8c3dd41e9c8eecb10143b41abe520b616607e754bf79c08331"},
+		// exactly two blocks, the last one is only compressed in Sum
+		{256, Size, "93463ac058b6163eb43be3f5bb32b28541498f4e3366f1effe253ad44e1e076e" +
+			"41c3616046027c82a7124f8f4746668ad10b12e8e25a95ac8f3151df01cd5a93"},
+	}
+	for _, tt := range tests {
+		for _, chunk := range []int{1, 7, 127, 128, 129, 1000} {
+			h := New(tt.size)
+			for p := data[:tt.n]; len(p) > 0; {
+				c := min(chunk, len(p))
+				h.Write(p[:c])
+				p = p[c:]
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != tt.want {
+				t.Errorf("%d bytes in chunks of %d, size %d: got %s, want %s", tt.n, chunk, tt.size, got, tt.want)
+			}
+		}
+	}
+}
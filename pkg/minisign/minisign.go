@@ -0,0 +1,231 @@
+// Package minisign reads minisign keys and creates and verifies
+// minisign compatible signatures (https://jedisct1.github.io/minisign/).
+//
+// scrypt for encrypted keys is implemented here instead of importing
+// golang.org/x/crypto/scrypt: go-xbuild-go has no dependencies outside the
+// standard library, so it builds and installs without downloading modules.
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/muquit/go-xbuild-go/pkg/blake2b"
+)
+
+const (
+	secretKeyLen = 158
+	publicKeyLen = 42
+	signatureLen = 74
+)
+
+var (
+	algEd      = []byte("Ed") // legacy signature over the whole file
+	algHashed  = []byte("ED") // signature over the BLAKE2b-512 of the file
+	kdfScrypt  = []byte("Sc")
+	kdfNone    = []byte{0, 0}
+	chkBlake2b = []byte("B2")
+)
+
+// ErrPassword is returned when a secret key can not be decrypted
+var ErrPassword = errors.New("minisign: wrong password for secret key")
+
+// PrivateKey is a decrypted minisign secret key
+type PrivateKey struct {
+	ID  [8]byte
+	Key ed25519.PrivateKey
+}
+
+// PublicKey is a minisign public key
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// Public returns the public key of k
+func (k *PrivateKey) Public() *PublicKey {
+	return &PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// KeyID returns the key id the way minisign prints it
+func (k *PublicKey) KeyID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.ID[:]))
+}
+
+// String returns the public key in minisign .pub file format
+func (k *PublicKey) String() string {
+	b := make([]byte, 0, publicKeyLen)
+	b = append(b, algEd...)
+	b = append(b, k.ID[:]...)
+	b = append(b, k.Key...)
+	return fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n",
+		k.KeyID(), base64.StdEncoding.EncodeToString(b))
+}
+
+// Return the base64 payload of a minisign key file. A bare base64
+// string without the comment line is accepted too.
+func decodeKey(data []byte) ([]byte, error) {
+	var payload string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		payload = line
+		break
+	}
+	if payload == "" {
+		return nil, errors.New("minisign: no key found")
+	}
+	b, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("minisign: invalid key encoding: %v", err)
+	}
+	return b, nil
+}
+
+// ParsePrivateKey parses a minisign secret key file, decrypting it with
+// password if the key is encrypted
+func ParsePrivateKey(data, password []byte) (*PrivateKey, error) {
+	b, err := decodeKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != secretKeyLen {
+		return nil, errors.New("minisign: invalid secret key length")
+	}
+	if !bytes.Equal(b[0:2], algEd) {
+		return nil, errors.New("minisign: unsupported signature algorithm")
+	}
+	if !bytes.Equal(b[4:6], chkBlake2b) {
+		return nil, errors.New("minisign: unsupported checksum algorithm")
+	}
+	salt := b[6:38]
+	opsLimit := binary.LittleEndian.Uint64(b[38:46])
+	memLimit := binary.LittleEndian.Uint64(b[46:54])
+	keynum := append([]byte(nil), b[54:]...)
+
+	switch {
+	case bytes.Equal(b[2:4], kdfScrypt):
+		if len(password) == 0 {
+			return nil, errors.New("minisign: secret key is encrypted but no password was given")
+		}
+		logN, r, p := pickParams(opsLimit, memLimit)
+		stream, err := scrypt(password, salt, logN, r, p, len(keynum))
+		if err != nil {
+			return nil, err
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	case bytes.Equal(b[2:4], kdfNone):
+	default:
+		return nil, errors.New("minisign: unsupported key derivation algorithm")
+	}
+
+	h := blake2b.New(blake2b.Size256)
+	h.Write(algEd)
+	h.Write(keynum[:72])
+	if subtle.ConstantTimeCompare(h.Sum(nil), keynum[72:]) != 1 {
+		return nil, ErrPassword
+	}
+
+	k := &PrivateKey{Key: ed25519.PrivateKey(keynum[8:72])}
+	copy(k.ID[:], keynum[:8])
+	return k, nil
+}
+
+// ParsePublicKey parses a minisign public key file or base64 string
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	b, err := decodeKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != publicKeyLen || !bytes.Equal(b[0:2], algEd) {
+		return nil, errors.New("minisign: invalid public key")
+	}
+	k := &PublicKey{Key: ed25519.PublicKey(b[10:])}
+	copy(k.ID[:], b[2:10])
+	return k, nil
+}
+
+// Sign reads the file content from r and returns a prehashed minisign
+// signature with the file name in the trusted comment
+func Sign(k *PrivateKey, r io.Reader, filename string) ([]byte, error) {
+	h := blake2b.New512()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 0, signatureLen)
+	sig = append(sig, algHashed...)
+	sig = append(sig, k.ID[:]...)
+	sig = append(sig, ed25519.Sign(k.Key, h.Sum(nil))...)
+
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), filename)
+	global := ed25519.Sign(k.Key, append(append([]byte(nil), sig[10:]...), trusted...))
+
+	var b bytes.Buffer
+	b.WriteString("untrusted comment: signature from minisign secret key\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(sig) + "\n")
+	b.WriteString("trusted comment: " + trusted + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	return b.Bytes(), nil
+}
+
+// Verify checks a minisign signature of the content read from r and
+// returns the trusted comment
+func Verify(k *PublicKey, r io.Reader, signature []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("minisign: invalid signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != signatureLen {
+		return "", errors.New("minisign: invalid signature")
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("minisign: invalid global signature")
+	}
+	if !bytes.Equal(sig[2:10], k.ID[:]) {
+		id := PublicKey{}
+		copy(id.ID[:], sig[2:10])
+		return "", fmt.Errorf("minisign: signature was made with key %s, not %s",
+			id.KeyID(), k.KeyID())
+	}
+
+	var message []byte
+	switch {
+	case bytes.Equal(sig[0:2], algHashed):
+		h := blake2b.New512()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+		message = h.Sum(nil)
+	case bytes.Equal(sig[0:2], algEd):
+		message, err = io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("minisign: unsupported signature algorithm")
+	}
+
+	if !ed25519.Verify(k.Key, message, sig[10:]) {
+		return "", errors.New("minisign: signature verification failed")
+	}
+	if !ed25519.Verify(k.Key, append(append([]byte(nil), sig[10:]...), trusted...), global) {
+		return "", errors.New("minisign: trusted comment verification failed")
+	}
+	return trusted, nil
+}
@@ -0,0 +1,98 @@
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The key pair in testdata was made with the minisign tool (password
+// "correct horse battery staple"), message.txt.minisig is its legacy
+// signature of message.txt. They come from the test data of
+// aead.dev/minisign.
+const testPassword = "correct horse battery staple"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scrypt with minisign's parameters takes 1 GiB")
+	}
+	data := readTestdata(t, "minisign.key")
+	k, err := ParsePrivateKey(data, []byte(testPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := k.Public().String(), string(readTestdata(t, "minisign.pub")); got != want {
+		t.Errorf("public key is\n%s\nwant\n%s", got, want)
+	}
+	if _, err := ParsePrivateKey(data, []byte("wrong")); err != ErrPassword {
+		t.Errorf("wrong password: got %v, want %v", err, ErrPassword)
+	}
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	k, err := ParsePublicKey(readTestdata(t, "minisign.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := k.KeyID(); got != "C373193807678450" {
+		t.Errorf("key id is %s, want C373193807678450", got)
+	}
+	message := readTestdata(t, "message.txt")
+	signature := readTestdata(t, "message.txt.minisig")
+	trusted, err := Verify(k, bytes.NewReader(message), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "timestamp:1614549543\tfile:message.txt"; trusted != want {
+		t.Errorf("trusted comment is %q, want %q", trusted, want)
+	}
+	if _, err := Verify(k, strings.NewReader("Hello World?\n"), signature); err == nil {
+		t.Errorf("signature of a different message verified")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &PrivateKey{ID: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, Key: key}
+	message := []byte("checksums\n")
+	signature, err := Sign(k, bytes.NewReader(message), "checksums.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the public key goes through its file format
+	pub, err := ParsePublicKey([]byte(k.Public().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := Verify(pub, bytes.NewReader(message), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(trusted, "\tfile:checksums.txt\thashed") {
+		t.Errorf("trusted comment is %q", trusted)
+	}
+
+	tampered := bytes.Replace(signature, []byte("file:checksums.txt"), []byte("file:checksums.bad"), 1)
+	if _, err := Verify(pub, bytes.NewReader(message), tampered); err == nil {
+		t.Errorf("signature with a tampered trusted comment verified")
+	}
+	if _, err := Verify(pub, bytes.NewReader([]byte("checksums!\n")), signature); err == nil {
+		t.Errorf("signature of a different message verified")
+	}
+}
@@ -0,0 +1,156 @@
+package minisign
+
+// scrypt (RFC 7914) as used by minisign to encrypt secret keys, with the
+// parameters picked from opslimit/memlimit the way libsodium does
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Pick scrypt N, r, p from the libsodium opslimit and memlimit
+func pickParams(opsLimit, memLimit uint64) (logN uint, r, p int) {
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	r = 8
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / (uint64(r) * 4)
+		for logN = 1; logN < 63; logN++ {
+			if uint64(1)<<logN > maxN/2 {
+				break
+			}
+		}
+		return logN, r, p
+	}
+
+	maxN := memLimit / (uint64(r) * 128)
+	for logN = 1; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	maxRP := (opsLimit / 4) / (uint64(1) << logN)
+	if maxRP > 0x3fffffff {
+		maxRP = 0x3fffffff
+	}
+	p = int(maxRP) / r
+	return logN, r, p
+}
+
+// Derive a key of keyLen bytes with scrypt
+func scrypt(password, salt []byte, logN uint, r, p, keyLen int) ([]byte, error) {
+	if logN < 1 || logN > 40 || r < 1 || p < 1 || uint64(r)*uint64(p) >= 1<<30 {
+		return nil, errors.New("minisign: invalid scrypt parameters")
+	}
+	n := 1 << logN
+
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	if err != nil {
+		return nil, err
+	}
+	xy := make([]uint32, 64*r)
+	v := make([]uint32, 32*n*r)
+	for i := 0; i < p; i++ {
+		smix(b[i*128*r:], r, n, v, xy)
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// salsa20/8 core on b, xor'ed with x first
+func salsaXOR(x *[16]uint32, in, out []uint32) {
+	var w [16]uint32
+	for i := range w {
+		x[i] ^= in[i]
+		w[i] = x[i]
+	}
+	for i := 0; i < 8; i += 2 {
+		w[4] ^= bits.RotateLeft32(w[0]+w[12], 7)
+		w[8] ^= bits.RotateLeft32(w[4]+w[0], 9)
+		w[12] ^= bits.RotateLeft32(w[8]+w[4], 13)
+		w[0] ^= bits.RotateLeft32(w[12]+w[8], 18)
+		w[9] ^= bits.RotateLeft32(w[5]+w[1], 7)
+		w[13] ^= bits.RotateLeft32(w[9]+w[5], 9)
+		w[1] ^= bits.RotateLeft32(w[13]+w[9], 13)
+		w[5] ^= bits.RotateLeft32(w[1]+w[13], 18)
+		w[14] ^= bits.RotateLeft32(w[10]+w[6], 7)
+		w[2] ^= bits.RotateLeft32(w[14]+w[10], 9)
+		w[6] ^= bits.RotateLeft32(w[2]+w[14], 13)
+		w[10] ^= bits.RotateLeft32(w[6]+w[2], 18)
+		w[3] ^= bits.RotateLeft32(w[15]+w[11], 7)
+		w[7] ^= bits.RotateLeft32(w[3]+w[15], 9)
+		w[11] ^= bits.RotateLeft32(w[7]+w[3], 13)
+		w[15] ^= bits.RotateLeft32(w[11]+w[7], 18)
+		w[1] ^= bits.RotateLeft32(w[0]+w[3], 7)
+		w[2] ^= bits.RotateLeft32(w[1]+w[0], 9)
+		w[3] ^= bits.RotateLeft32(w[2]+w[1], 13)
+		w[0] ^= bits.RotateLeft32(w[3]+w[2], 18)
+		w[6] ^= bits.RotateLeft32(w[5]+w[4], 7)
+		w[7] ^= bits.RotateLeft32(w[6]+w[5], 9)
+		w[4] ^= bits.RotateLeft32(w[7]+w[6], 13)
+		w[5] ^= bits.RotateLeft32(w[4]+w[7], 18)
+		w[11] ^= bits.RotateLeft32(w[10]+w[9], 7)
+		w[8] ^= bits.RotateLeft32(w[11]+w[10], 9)
+		w[9] ^= bits.RotateLeft32(w[8]+w[11], 13)
+		w[10] ^= bits.RotateLeft32(w[9]+w[8], 18)
+		w[12] ^= bits.RotateLeft32(w[15]+w[14], 7)
+		w[13] ^= bits.RotateLeft32(w[12]+w[15], 9)
+		w[14] ^= bits.RotateLeft32(w[13]+w[12], 13)
+		w[15] ^= bits.RotateLeft32(w[14]+w[13], 18)
+	}
+	for i := range w {
+		x[i] += w[i]
+		out[i] = x[i]
+	}
+}
+
+// BlockMix of scrypt
+func blockMix(tmp *[16]uint32, in, out []uint32, r int) {
+	copy(tmp[:], in[(2*r-1)*16:])
+	for i := 0; i < 2*r; i += 2 {
+		salsaXOR(tmp, in[i*16:], out[i*8:])
+		salsaXOR(tmp, in[i*16+16:], out[i*8+r*16:])
+	}
+}
+
+func integer(b []uint32, r int) uint64 {
+	j := (2*r - 1) * 16
+	return uint64(b[j]) | uint64(b[j+1])<<32
+}
+
+// ROMix of scrypt
+func smix(b []byte, r, n int, v, xy []uint32) {
+	var tmp [16]uint32
+	R := 32 * r
+	x := xy
+	y := xy[R:]
+
+	for i := 0; i < R; i++ {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < n; i += 2 {
+		copy(v[i*R:], x)
+		blockMix(&tmp, x, y, r)
+		copy(v[(i+1)*R:], y)
+		blockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < n; i += 2 {
+		j := int(integer(x, r) & uint64(n-1))
+		for k := 0; k < R; k++ {
+			x[k] ^= v[j*R+k]
+		}
+		blockMix(&tmp, x, y, r)
+		j = int(integer(y, r) & uint64(n-1))
+		for k := 0; k < R; k++ {
+			y[k] ^= v[j*R+k]
+		}
+		blockMix(&tmp, y, x, r)
+	}
+	for i := 0; i < R; i++ {
+		binary.LittleEndian.PutUint32(b[i*4:], x[i])
+	}
+}
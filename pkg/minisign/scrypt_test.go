@@ -0,0 +1,45 @@
+package minisign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of RFC 7914 section 12, without the N = 1048576 one
+func TestScrypt(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		logN           uint
+		r, p           int
+		want           string
+	}{
+		{"", "", 4, 1, 1,
+			"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+				"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 10, 8, 16,
+			"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+				"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 14, 8, 1,
+			"7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2" +
+				"d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		got, err := scrypt([]byte(tt.password), []byte(tt.salt), tt.logN, tt.r, tt.p, len(want))
+		if err != nil {
+			t.Fatalf("scrypt(%q, %q): %v", tt.password, tt.salt, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("scrypt(%q, %q) = %x, want %x", tt.password, tt.salt, got, want)
+		}
+	}
+}
+
+// minisign's default opslimit and memlimit are scrypt N = 2^20, r = 8, p = 1
+func TestPickParams(t *testing.T) {
+	logN, r, p := pickParams(33554432, 1073741824)
+	if logN != 20 || r != 8 || p != 1 {
+		t.Errorf("pickParams = %d, %d, %d, want 20, 8, 1", logN, r, p)
+	}
+}
@@ -0,0 +1,422 @@
+// Package zstd implements a Zstandard (RFC 8878) compressor for Arch Linux
+// packages. Blocks are compressed with LZ77 matches encoded with the
+// predefined FSE tables, literals are stored raw. Runs of a single byte
+// become RLE blocks and blocks that don't shrink are stored raw.
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	magic     = 0xfd2fb528
+	windowLog = 22 // 4 MiB window
+	maxBlock  = 128 << 10
+	minMatch  = 4
+	hashLog   = 17
+	maxChain  = 8   // match candidates tried per position
+	goodMatch = 128 // matches long enough to stop searching
+)
+
+const (
+	blockRaw        = 0
+	blockRLE        = 1
+	blockCompressed = 2
+)
+
+// Encode compresses data into a single zstd frame
+func Encode(data []byte) []byte {
+	out := make([]byte, 0, len(data)/2+16)
+	out = binary.LittleEndian.AppendUint32(out, magic)
+	// frame header descriptor without content size, checksum or
+	// dictionary, window descriptor with the exponent only
+	out = append(out, 0x00, (windowLog-10)<<3)
+
+	e := newEncoder(data)
+	for start := 0; ; start += maxBlock {
+		end := min(start+maxBlock, len(data))
+		last := end == len(data)
+		out = e.block(out, start, end, last)
+		if last {
+			return out
+		}
+	}
+}
+
+// A match of an LZ77 sequence, the literals before it and its offset
+type sequence struct {
+	litLen   int
+	matchLen int
+	offset   int
+}
+
+type encoder struct {
+	data []byte
+	head []int32 // last position of each hash
+	prev []int32 // previous position with the same hash
+	next int     // next position to insert
+}
+
+func newEncoder(data []byte) *encoder {
+	e := &encoder{data: data, head: make([]int32, 1<<hashLog), prev: make([]int32, len(data))}
+	for i := range e.head {
+		e.head[i] = -1
+	}
+	return e
+}
+
+func (e *encoder) hash(i int) uint32 {
+	return binary.LittleEndian.Uint32(e.data[i:]) * 2654435761 >> (32 - hashLog)
+}
+
+// Insert the positions up to i into the hash chains
+func (e *encoder) insert(i int) {
+	for ; e.next < i; e.next++ {
+		if e.next+4 > len(e.data) {
+			continue
+		}
+		h := e.hash(e.next)
+		e.prev[e.next] = e.head[h]
+		e.head[h] = int32(e.next)
+	}
+}
+
+// Find the longest match at i not reaching past end
+func (e *encoder) match(i, end int) (length, offset int) {
+	if i+minMatch > end {
+		return 0, 0
+	}
+	e.insert(i)
+	cand := e.head[e.hash(i)]
+	for n := 0; cand >= 0 && n < maxChain; n++ {
+		c := int(cand)
+		if i-c >= 1<<windowLog {
+			break
+		}
+		if e.data[c+length] == e.data[i+length] {
+			l := matchLen(e.data[c:end], e.data[i:end])
+			if l > length {
+				length, offset = l, i-c
+				if i+l == end || l >= goodMatch {
+					break
+				}
+			}
+		}
+		cand = e.prev[c]
+	}
+	if length < minMatch {
+		return 0, 0
+	}
+	return length, offset
+}
+
+// Get the length of the common prefix of a and b, b is not longer than a
+func matchLen(a, b []byte) int {
+	n := 0
+	for n+8 <= len(b) {
+		if x := binary.LittleEndian.Uint64(a[n:]) ^ binary.LittleEndian.Uint64(b[n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// Append the block of data[start:end], the smallest of RLE, compressed or raw
+func (e *encoder) block(out []byte, start, end int, last bool) []byte {
+	src := e.data[start:end]
+	if len(src) > 0 && isRun(src) {
+		e.insert(end)
+		out = appendBlockHeader(out, blockRLE, len(src), last)
+		return append(out, src[0])
+	}
+
+	var seqs []sequence
+	var literals []byte
+	anchor := start
+	for i := start; i < end; {
+		length, offset := e.match(i, end)
+		if length == 0 {
+			i++
+			continue
+		}
+		// lazy matching: prefer a longer match at the next position
+		if length < goodMatch {
+			if l, o := e.match(i+1, end); l > length+1 {
+				i, length, offset = i+1, l, o
+			}
+		}
+		literals = append(literals, e.data[anchor:i]...)
+		seqs = append(seqs, sequence{litLen: i - anchor, matchLen: length, offset: offset})
+		i += length
+		anchor = i
+	}
+	e.insert(end)
+	literals = append(literals, e.data[anchor:end]...)
+
+	if len(seqs) > 0 {
+		content := appendLiterals(nil, literals)
+		content = appendSequences(content, seqs)
+		if len(content) < len(src) {
+			out = appendBlockHeader(out, blockCompressed, len(content), last)
+			return append(out, content...)
+		}
+	}
+	out = appendBlockHeader(out, blockRaw, len(src), last)
+	return append(out, src...)
+}
+
+// Check if data is one byte repeated
+func isRun(data []byte) bool {
+	for _, b := range data[1:] {
+		if b != data[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func appendBlockHeader(out []byte, blockType, size int, last bool) []byte {
+	header := uint32(blockType)<<1 | uint32(size)<<3
+	if last {
+		header |= 1
+	}
+	return append(out, byte(header), byte(header>>8), byte(header>>16))
+}
+
+// Append a raw literals section
+func appendLiterals(out, literals []byte) []byte {
+	n := len(literals)
+	switch {
+	case n < 32:
+		out = append(out, byte(n<<3))
+	case n < 4096:
+		out = append(out, byte(n<<4|1<<2), byte(n>>4))
+	default:
+		out = append(out, byte(n<<4|3<<2), byte(n>>4), byte(n>>12))
+	}
+	return append(out, literals...)
+}
+
+// Append the sequences section with the predefined FSE tables
+func appendSequences(out []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7f00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 0xff, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	// predefined mode for literal lengths, offsets and match lengths
+	out = append(out, 0)
+
+	type coded struct {
+		llCode, ofCode, mlCode    uint8
+		llExtra, ofExtra, mlExtra uint32
+	}
+	codes := make([]coded, n)
+	for i, s := range seqs {
+		c := &codes[i]
+		c.llCode, c.llExtra = lengthCode(llBase[:], uint32(s.litLen))
+		c.mlCode, c.mlExtra = lengthCode(mlBase[:], uint32(s.matchLen))
+		// offsets are never repeat codes, the offset value is offset + 3
+		value := uint32(s.offset + 3)
+		c.ofCode = uint8(bits.Len32(value) - 1)
+		c.ofExtra = value - 1<<c.ofCode
+	}
+
+	// the decoder reads the bitstream backwards, the sequences are
+	// written last to first
+	var w bitWriter
+	c := codes[n-1]
+	llState := llTable.init(c.llCode)
+	ofState := ofTable.init(c.ofCode)
+	mlState := mlTable.init(c.mlCode)
+	w.add(c.llExtra, llBits[c.llCode])
+	w.add(c.mlExtra, mlBits[c.mlCode])
+	w.add(c.ofExtra, c.ofCode)
+	for i := n - 2; i >= 0; i-- {
+		c := codes[i]
+		ofTable.encode(&w, &ofState, c.ofCode)
+		mlTable.encode(&w, &mlState, c.mlCode)
+		llTable.encode(&w, &llState, c.llCode)
+		w.add(c.llExtra, llBits[c.llCode])
+		w.add(c.mlExtra, mlBits[c.mlCode])
+		w.add(c.ofExtra, c.ofCode)
+	}
+	w.add(mlState, mlTable.log)
+	w.add(ofState, ofTable.log)
+	w.add(llState, llTable.log)
+	return append(out, w.close()...)
+}
+
+// Get the code of a literal or match length and its extra bits value
+func lengthCode(base []uint32, length uint32) (uint8, uint32) {
+	code := len(base) - 1
+	for base[code] > length {
+		code--
+	}
+	return uint8(code), length - base[code]
+}
+
+// Literal length codes
+var (
+	llBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+)
+
+// Match length codes
+var (
+	mlBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined FSE distributions (RFC 8878 section 3.1.1.3.2.2)
+var (
+	llTable = newFSETable(6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	})
+	mlTable = newFSETable(6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	})
+	ofTable = newFSETable(5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	})
+)
+
+// fseTable is an FSE encoding table. States are kept as decoder state
+// plus table size.
+type fseTable struct {
+	log     uint8
+	states  []uint32 // next states by cumulative position
+	symbols []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaBits  uint32 // added to the state, the top 16 bits are the number of bits
+	deltaState int32  // offset of the symbol's states in states
+}
+
+func newFSETable(log uint8, norm []int16) *fseTable {
+	size := 1 << log
+	t := &fseTable{log: log, states: make([]uint32, size), symbols: make([]fseSymbol, len(norm))}
+
+	// spread the symbols like the decoder does, "less than 1"
+	// probabilities at the end of the table
+	spread := make([]uint8, size)
+	cumul := make([]int, len(norm)+1)
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			spread[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			spread[pos] = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+
+	for u, s := range spread {
+		t.states[cumul[s]] = uint32(size + u)
+		cumul[s]++
+	}
+
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case -1, 1:
+			t.symbols[s] = fseSymbol{deltaBits: uint32(log)<<16 - uint32(size), deltaState: int32(total - 1)}
+			total++
+		default:
+			maxBits := uint32(log) - uint32(bits.Len16(uint16(n-1))-1)
+			minState := uint32(n) << maxBits
+			t.symbols[s] = fseSymbol{deltaBits: maxBits<<16 - minState, deltaState: int32(total - int(n))}
+			total += int(n)
+		}
+	}
+	return t
+}
+
+// Get the initial state for the last symbol of the stream
+func (t *fseTable) init(symbol uint8) uint32 {
+	s := t.symbols[symbol]
+	nbBits := (s.deltaBits + 1<<15) >> 16
+	value := nbBits<<16 - s.deltaBits
+	return t.states[int32(value>>nbBits)+s.deltaState]
+}
+
+// Write the bits of the state and move to the state for symbol
+func (t *fseTable) encode(w *bitWriter, state *uint32, symbol uint8) {
+	s := t.symbols[symbol]
+	nbBits := (*state + s.deltaBits) >> 16
+	w.add(*state, uint8(nbBits))
+	*state = t.states[int32(*state>>nbBits)+s.deltaState]
+}
+
+// bitWriter writes bits from the least significant end, read backwards
+// by the decoder
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint8
+}
+
+func (w *bitWriter) add(value uint32, n uint8) {
+	w.acc |= uint64(value&(1<<n-1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// Write the end mark and pad to a byte
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}
@@ -0,0 +1,221 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// in-toto statement with a SLSA v1 provenance predicate listing the
+// archives, SBOMs and packages of the build with their sha256 digests
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	provenanceBuildType = url + "/buildtypes/go@v1"
+	provenanceExt       = ".intoto.jsonl"
+)
+
+// ProvenanceConfig configures the provenance statement
+type ProvenanceConfig struct {
+	BuilderID  string `json:"builder_id"` // default go-xbuild-go URL, e.g. the CI workflow URL
+	Repository string `json:"repository"` // source repository URL, default git remote origin
+}
+
+// inTotoStatement is an in-toto v1 statement
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// slsaProvenance is the SLSA v1 provenance predicate
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters"`
+	ResolvedDependencies []slsaResource         `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaResource struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type slsaRunDetails struct {
+	Builder  slsaBuilder  `json:"builder"`
+	Metadata slsaMetadata `json:"metadata"`
+}
+
+type slsaBuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type slsaMetadata struct {
+	StartedOn  string `json:"startedOn"`
+	FinishedOn string `json:"finishedOn"`
+}
+
+// provenanceInvocation is the go build of one target/platform
+type provenanceInvocation struct {
+	Target   string   `json:"target"`
+	Platform string   `json:"platform"`
+	Command  []string `json:"command"`
+	Env      []string `json:"env"`
+}
+
+// Name of the provenance file
+func provenanceFileName(config *Config, version string) string {
+	return fmt.Sprintf("%s-%s%s", config.ProjectName, version, provenanceExt)
+}
+
+// URL of the git remote origin as a SLSA resource URI, e.g.
+// git+https://github.com/muquit/go-xbuild-go
+func gitRepository(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "git", "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	return gitURI(strings.TrimSpace(string(out)))
+}
+
+// Convert a git remote URL to a git+https URI, scp-like
+// git@host:owner/repo.git is converted too
+func gitURI(remote string) string {
+	remote = strings.TrimSuffix(remote, ".git")
+	if strings.HasPrefix(remote, "git+") {
+		return remote
+	}
+	if at := strings.Index(remote, "@"); at >= 0 && !strings.Contains(remote, "://") {
+		remote = "https://" + strings.Replace(remote[at+1:], ":", "/", 1)
+	}
+	if strings.HasPrefix(remote, "ssh://") {
+		remote = "https://" + remote[strings.Index(remote, "@")+1:]
+	}
+	return "git+" + remote
+}
+
+// Write the provenance statement covering the archives, SBOMs and packages
+// of the build, signed with the signing key if there is one
+func writeProvenance(ctx context.Context, config *Config, version string) error {
+	if config.Provenance == nil {
+		return nil
+	}
+	path := filepath.Join(config.BinDir, provenanceFileName(config, version))
+	if config.DryRun {
+		fmt.Printf("Would write %s\n", filepath.Base(path))
+		return signFiles(ctx, config, []string{path})
+	}
+
+	var subjects []inTotoSubject
+	var invocations []provenanceInvocation
+	for _, a := range config.Artifacts.Artifacts {
+		for _, name := range a.files() {
+			digest, err := sha256File(filepath.Join(config.BinDir, name))
+			if err != nil {
+				return fmt.Errorf("provenance: %v", err)
+			}
+			subjects = append(subjects, inTotoSubject{Name: name, Digest: map[string]string{"sha256": digest}})
+		}
+		invocations = append(invocations, provenanceInvocation{
+			Target:   a.Target,
+			Platform: a.Platform,
+			Command:  a.BuildCommand,
+			Env:      a.BuildEnv,
+		})
+	}
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i].Name < subjects[j].Name })
+
+	goVersion, err := goEnv(ctx, "GOVERSION")
+	if err != nil {
+		return fmt.Errorf("provenance: %v", err)
+	}
+
+	repository := config.Provenance.Repository
+	if repository == "" {
+		repository = gitRepository(ctx)
+	} else {
+		repository = gitURI(repository)
+	}
+	var dependencies []slsaResource
+	if repository != "" {
+		source := slsaResource{URI: repository}
+		if c := gitCommit(ctx); c != "" {
+			source.Digest = map[string]string{"gitCommit": c}
+		}
+		dependencies = append(dependencies, source)
+	}
+
+	builderID := config.Provenance.BuilderID
+	if builderID == "" {
+		builderID = url
+	}
+
+	external := map[string]interface{}{
+		"project": config.ProjectName,
+		"version": version,
+	}
+	if repository != "" {
+		external["repository"] = repository
+	}
+	statement := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       subjects,
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType:          provenanceBuildType,
+				ExternalParameters: external,
+				InternalParameters: map[string]interface{}{
+					"goVersion":   goVersion,
+					"host":        runtime.GOOS + "/" + runtime.GOARCH,
+					"invocations": invocations,
+				},
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{
+					ID:      builderID,
+					Version: map[string]string{me: versionString()},
+				},
+				Metadata: slsaMetadata{
+					StartedOn:  buildStart.Format(time.RFC3339),
+					FinishedOn: time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+
+	// one statement per line
+	data, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s\n", filepath.Base(path))
+	config.Summary.add("provenance", filepath.Base(path))
+	return signFiles(ctx, config, []string{path})
+}
@@ -0,0 +1,513 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// RPM packages written in Go: lead, signature header, header and a
+// gzipped cpio payload, no rpmbuild needed
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RPMConfig has the rpm specific settings of the packages section
+type RPMConfig struct {
+	Depends []string `json:"depends"` // e.g. ["glibc >= 2.17"], default packages depends
+	Group   string   `json:"group"`   // default "Unspecified"
+	Sign    bool     `json:"sign"`    // sign the header with the gpg signing backend
+}
+
+// RPM architectures of GOARCH
+var rpmArchs = map[string]string{
+	"386":     "i686",
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"loong64": "loongarch64",
+	"ppc64le": "ppc64le",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// Architecture numbers of the lead, ignored by rpm nowadays
+var rpmArchNums = map[string]int16{
+	"i686":     1,
+	"x86_64":   1,
+	"armv7hl":  12,
+	"armv6hl":  12,
+	"armv5tel": 12,
+	"ppc64le":  16,
+	"s390x":    15,
+	"aarch64":  19,
+}
+
+// RPM architecture of a platform, arm is armv7hl for GOARM 7 (the
+// default), armv6hl and armv5tel for GOARM 6 and 5
+func rpmArch(p platform) (string, bool) {
+	if p.GOARCH == "arm" {
+		switch p.GOARM {
+		case "5":
+			return "armv5tel", true
+		case "6":
+			return "armv6hl", true
+		}
+		return "armv7hl", true
+	}
+	arch, ok := rpmArchs[p.GOARCH]
+	return arch, ok
+}
+
+// Header tags
+const (
+	rpmTagHeaderSignatures  = 62
+	rpmTagHeaderImmutable   = 63
+	rpmTagHeaderI18nTable   = 100
+	rpmSigTagSHA1           = 269
+	rpmSigTagSHA256         = 273
+	rpmSigTagRSA            = 268
+	rpmSigTagSize           = 1000
+	rpmSigTagMD5            = 1004
+	rpmSigTagPayloadSize    = 1007
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagVendor            = 1011
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPreIn             = 1023
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagPostUn            = 1026
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMtimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagSourceRPM         = 1044
+	rpmTagFileVerifyFlags   = 1045
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagPreInProg         = 1085
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagPostUnProg        = 1088
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+)
+
+// Header value types
+const (
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeBin         = 7
+	rpmTypeStringArray = 8
+	rpmTypeI18nString  = 9
+)
+
+// Dependency flags
+const (
+	rpmSenseLess    = 0x02
+	rpmSenseGreater = 0x04
+	rpmSenseEqual   = 0x08
+	rpmSenseRPMLib  = 0x1000000
+)
+
+// File flags
+const (
+	rpmFileConfig    = 1 << 0
+	rpmFileNoReplace = 1 << 4
+)
+
+const rpmDigestSHA256 = 8
+
+// rpmHeader is a header structure: index entries and data store
+type rpmHeader struct {
+	region  int32
+	entries map[int32]rpmEntry
+}
+
+type rpmEntry struct {
+	typ   int32
+	count int32
+	data  []byte
+}
+
+func newRPMHeader(region int32) *rpmHeader {
+	return &rpmHeader{region: region, entries: make(map[int32]rpmEntry)}
+}
+
+func (h *rpmHeader) addString(tag int32, s string) {
+	h.entries[tag] = rpmEntry{typ: rpmTypeString, count: 1, data: append([]byte(s), 0)}
+}
+
+func (h *rpmHeader) addI18nString(tag int32, s string) {
+	h.entries[tag] = rpmEntry{typ: rpmTypeI18nString, count: 1, data: append([]byte(s), 0)}
+}
+
+func (h *rpmHeader) addStrings(tag int32, values []string) {
+	var data []byte
+	for _, s := range values {
+		data = append(append(data, s...), 0)
+	}
+	h.entries[tag] = rpmEntry{typ: rpmTypeStringArray, count: int32(len(values)), data: data}
+}
+
+func (h *rpmHeader) addBin(tag int32, data []byte) {
+	h.entries[tag] = rpmEntry{typ: rpmTypeBin, count: int32(len(data)), data: data}
+}
+
+func (h *rpmHeader) addInt32(tag int32, values ...int32) {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(data[4*i:], uint32(v))
+	}
+	h.entries[tag] = rpmEntry{typ: rpmTypeInt32, count: int32(len(values)), data: data}
+}
+
+func (h *rpmHeader) addInt16(tag int32, values ...int16) {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	h.entries[tag] = rpmEntry{typ: rpmTypeInt16, count: int32(len(values)), data: data}
+}
+
+// Serialize the header with its immutable region
+func (h *rpmHeader) bytes() []byte {
+	tags := make([]int32, 0, len(h.entries))
+	for tag := range h.entries {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	var store bytes.Buffer
+	offsets := make([]int, len(tags))
+	for i, tag := range tags {
+		e := h.entries[tag]
+		align := 1
+		switch e.typ {
+		case rpmTypeInt16:
+			align = 2
+		case rpmTypeInt32:
+			align = 4
+		}
+		for store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		offsets[i] = store.Len()
+		store.Write(e.data)
+	}
+
+	// the region trailer is an index entry pointing back over all entries
+	entries := int32(len(tags) + 1)
+	trailerOffset := store.Len()
+	trailer := make([]byte, 16)
+	binary.BigEndian.PutUint32(trailer[0:], uint32(h.region))
+	binary.BigEndian.PutUint32(trailer[4:], rpmTypeBin)
+	binary.BigEndian.PutUint32(trailer[8:], uint32(-16*entries))
+	binary.BigEndian.PutUint32(trailer[12:], 16)
+	store.Write(trailer)
+
+	var b bytes.Buffer
+	b.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&b, binary.BigEndian, []int32{entries, int32(store.Len())})
+	binary.Write(&b, binary.BigEndian, []int32{h.region, rpmTypeBin, int32(trailerOffset), 16})
+	for i, tag := range tags {
+		e := h.entries[tag]
+		binary.Write(&b, binary.BigEndian, []int32{tag, e.typ, int32(offsets[i]), e.count})
+	}
+	b.Write(store.Bytes())
+	return b.Bytes()
+}
+
+// Dependency like "glibc >= 2.17" or Debian style "libc6 (>= 2.31)"
+var rpmDependency = regexp.MustCompile(`^([^\s()<>=]+)\s*\(?\s*(<<|>>|<=|>=|<|>|=)?\s*([^\s)]*)\s*\)?$`)
+
+// Parse a dependency into name, flags and version
+func parseRPMDependency(dep string) (string, int32, string, error) {
+	m := rpmDependency.FindStringSubmatch(strings.TrimSpace(dep))
+	if m == nil || (m[2] == "") != (m[3] == "") {
+		return "", 0, "", fmt.Errorf("invalid dependency %q", dep)
+	}
+	flags := map[string]int32{
+		"":   0,
+		"<<": rpmSenseLess,
+		"<":  rpmSenseLess,
+		">>": rpmSenseGreater,
+		">":  rpmSenseGreater,
+		"<=": rpmSenseLess | rpmSenseEqual,
+		">=": rpmSenseGreater | rpmSenseEqual,
+		"=":  rpmSenseEqual,
+	}
+	return m[1], flags[m[2]], m[3], nil
+}
+
+// Write a newc cpio entry
+func writeCpioEntry(w *bytes.Buffer, name string, ino, mode int64, data []byte) {
+	fmt.Fprintf(w, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, 1, buildStart.Unix(), len(data), 0, 0, 0, 0, len(name)+1, 0)
+	w.WriteString(name)
+	w.WriteByte(0)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+	w.Write(data)
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+// Build an .rpm package. The header is signed with the gpg signer if
+// sign is set.
+func buildRPM(ctx context.Context, c *packageContent, arch string, s *signer, sign bool) ([]byte, error) {
+	rpmConfig := c.Config.RPM
+	if rpmConfig == nil {
+		rpmConfig = &RPMConfig{}
+	}
+
+	// payload: gzipped cpio with ./ prefixed names
+	var cpio bytes.Buffer
+	for i, f := range c.Files {
+		writeCpioEntry(&cpio, "."+f.Dst, int64(i+1), 0100000|f.Mode, f.Data)
+	}
+	writeCpioEntry(&cpio, "TRAILER!!!", 0, 0, nil)
+	var payload bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&payload, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write(cpio.Bytes())
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	h := newRPMHeader(rpmTagHeaderImmutable)
+	h.addStrings(rpmTagHeaderI18nTable, []string{"C"})
+	h.addString(rpmTagName, c.Name)
+	h.addString(rpmTagVersion, c.Version)
+	h.addString(rpmTagRelease, c.Release)
+	h.addI18nString(rpmTagSummary, c.summary())
+	description := c.Config.Description
+	if description == "" {
+		description = c.summary()
+	}
+	h.addI18nString(rpmTagDescription, description)
+	h.addInt32(rpmTagBuildTime, int32(buildStart.Unix()))
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "localhost"
+	}
+	h.addString(rpmTagBuildHost, host)
+	h.addInt32(rpmTagSize, int32(c.size()))
+	if c.Config.Vendor != "" {
+		h.addString(rpmTagVendor, c.Config.Vendor)
+	}
+	license := c.Config.License
+	if license == "" {
+		license = "Unknown"
+	}
+	h.addString(rpmTagLicense, license)
+	if c.Config.Maintainer != "" {
+		h.addString(rpmTagPackager, c.Config.Maintainer)
+	}
+	group := rpmConfig.Group
+	if group == "" {
+		group = "Unspecified"
+	}
+	h.addI18nString(rpmTagGroup, group)
+	if c.Config.Homepage != "" {
+		h.addString(rpmTagURL, c.Config.Homepage)
+	}
+	h.addString(rpmTagOS, "linux")
+	h.addString(rpmTagArch, arch)
+	h.addString(rpmTagSourceRPM, fmt.Sprintf("%s-%s-%s.src.rpm", c.Name, c.Version, c.Release))
+
+	scripts := []struct {
+		kind      string
+		tag, prog int32
+	}{
+		{"preinstall", rpmTagPreIn, rpmTagPreInProg},
+		{"postinstall", rpmTagPostIn, rpmTagPostInProg},
+		{"preremove", rpmTagPreUn, rpmTagPreUnProg},
+		{"postremove", rpmTagPostUn, rpmTagPostUnProg},
+	}
+	for _, script := range scripts {
+		if data, ok := c.Scripts[script.kind]; ok {
+			h.addString(script.tag, string(data))
+			h.addString(script.prog, "/bin/sh")
+		}
+	}
+
+	// files
+	n := len(c.Files)
+	sizes := make([]int32, n)
+	modes := make([]int16, n)
+	rdevs := make([]int16, n)
+	mtimes := make([]int32, n)
+	digests := make([]string, n)
+	linktos := make([]string, n)
+	flags := make([]int32, n)
+	users := make([]string, n)
+	groups := make([]string, n)
+	verify := make([]int32, n)
+	devices := make([]int32, n)
+	inodes := make([]int32, n)
+	langs := make([]string, n)
+	dirIndexes := make([]int32, n)
+	baseNames := make([]string, n)
+	var dirNames []string
+	dirIndex := make(map[string]int32)
+	for i, f := range c.Files {
+		sizes[i] = int32(len(f.Data))
+		modes[i] = int16(0100000 | f.Mode)
+		mtimes[i] = int32(buildStart.Unix())
+		sum := sha256.Sum256(f.Data)
+		digests[i] = hex.EncodeToString(sum[:])
+		if f.Config {
+			flags[i] = rpmFileConfig | rpmFileNoReplace
+		}
+		users[i] = "root"
+		groups[i] = "root"
+		verify[i] = -1
+		devices[i] = 1
+		inodes[i] = int32(i + 1)
+		dir := path.Dir(f.Dst) + "/"
+		if _, ok := dirIndex[dir]; !ok {
+			dirIndex[dir] = int32(len(dirNames))
+			dirNames = append(dirNames, dir)
+		}
+		dirIndexes[i] = dirIndex[dir]
+		baseNames[i] = path.Base(f.Dst)
+	}
+	h.addInt32(rpmTagFileSizes, sizes...)
+	h.addInt16(rpmTagFileModes, modes...)
+	h.addInt16(rpmTagFileRdevs, rdevs...)
+	h.addInt32(rpmTagFileMtimes, mtimes...)
+	h.addStrings(rpmTagFileDigests, digests)
+	h.addStrings(rpmTagFileLinkTos, linktos)
+	h.addInt32(rpmTagFileFlags, flags...)
+	h.addStrings(rpmTagFileUserName, users)
+	h.addStrings(rpmTagFileGroupName, groups)
+	h.addInt32(rpmTagFileVerifyFlags, verify...)
+	h.addInt32(rpmTagFileDevices, devices...)
+	h.addInt32(rpmTagFileInodes, inodes...)
+	h.addStrings(rpmTagFileLangs, langs)
+	h.addInt32(rpmTagDirIndexes, dirIndexes...)
+	h.addStrings(rpmTagBaseNames, baseNames)
+	h.addStrings(rpmTagDirNames, dirNames)
+	h.addInt32(rpmTagFileDigestAlgo, rpmDigestSHA256)
+
+	// provides and requires
+	evr := c.Version + "-" + c.Release
+	h.addStrings(rpmTagProvideName, []string{c.Name})
+	h.addInt32(rpmTagProvideFlags, rpmSenseEqual)
+	h.addStrings(rpmTagProvideVersion, []string{evr})
+
+	requireNames := []string{"rpmlib(CompressedFileNames)", "rpmlib(FileDigests)", "rpmlib(PayloadFilesHavePrefix)"}
+	requireFlags := []int32{rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual, rpmSenseRPMLib | rpmSenseLess | rpmSenseEqual}
+	requireVersions := []string{"3.0.4-1", "4.6.0-1", "4.0-1"}
+	depends := c.Config.Depends
+	if len(rpmConfig.Depends) > 0 {
+		depends = rpmConfig.Depends
+	}
+	for _, dep := range depends {
+		name, flag, version, err := parseRPMDependency(dep)
+		if err != nil {
+			return nil, err
+		}
+		requireNames = append(requireNames, name)
+		requireFlags = append(requireFlags, flag)
+		requireVersions = append(requireVersions, version)
+	}
+	h.addStrings(rpmTagRequireName, requireNames)
+	h.addInt32(rpmTagRequireFlags, requireFlags...)
+	h.addStrings(rpmTagRequireVersion, requireVersions)
+
+	h.addString(rpmTagPayloadFormat, "cpio")
+	h.addString(rpmTagPayloadCompressor, "gzip")
+	h.addString(rpmTagPayloadFlags, "9")
+	payloadSum := sha256.Sum256(payload.Bytes())
+	h.addStrings(rpmTagPayloadDigest, []string{hex.EncodeToString(payloadSum[:])})
+	h.addInt32(rpmTagPayloadDigestAlgo, rpmDigestSHA256)
+	header := h.bytes()
+
+	// signature header: sizes and digests of header and payload
+	sig := newRPMHeader(rpmTagHeaderSignatures)
+	headerSHA1 := sha1.Sum(header)
+	headerSHA256 := sha256.Sum256(header)
+	md5sum := md5.New()
+	md5sum.Write(header)
+	md5sum.Write(payload.Bytes())
+	sig.addInt32(rpmSigTagSize, int32(len(header)+payload.Len()))
+	sig.addBin(rpmSigTagMD5, md5sum.Sum(nil))
+	sig.addInt32(rpmSigTagPayloadSize, int32(cpio.Len()))
+	sig.addString(rpmSigTagSHA1, hex.EncodeToString(headerSHA1[:]))
+	sig.addString(rpmSigTagSHA256, hex.EncodeToString(headerSHA256[:]))
+	if sign {
+		pgp, err := s.signData(ctx, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign rpm header: %v", err)
+		}
+		sig.addBin(rpmSigTagRSA, pgp)
+	}
+	sigHeader := sig.bytes()
+
+	// lead
+	var b bytes.Buffer
+	b.Write([]byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	binary.Write(&b, binary.BigEndian, int16(0)) // binary package
+	binary.Write(&b, binary.BigEndian, rpmArchNums[arch])
+	name := make([]byte, 66)
+	copy(name[:65], fmt.Sprintf("%s-%s-%s", c.Name, c.Version, c.Release))
+	b.Write(name)
+	binary.Write(&b, binary.BigEndian, int16(1)) // linux
+	binary.Write(&b, binary.BigEndian, int16(5)) // header style signature
+	b.Write(make([]byte, 16))
+
+	b.Write(sigHeader)
+	for b.Len()%8 != 0 {
+		b.WriteByte(0)
+	}
+	b.Write(header)
+	b.Write(payload.Bytes())
+	return b.Bytes(), nil
+}
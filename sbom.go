@@ -0,0 +1,345 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// SBOM of every binary, CycloneDX JSON and SPDX JSON, from the module
+// information go build embeds in the binary (debug/buildinfo)
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/muquit/go-xbuild-go/pkg/version"
+)
+
+// SBOM file name extensions
+const (
+	cycloneDXExt = ".cdx.json"
+	spdxExt      = ".spdx.json"
+)
+
+// sbomModule is a go module in a binary
+type sbomModule struct {
+	Path    string
+	Version string
+	PURL    string
+	ID      string // SPDX identifier
+}
+
+// Get the module actually used, the replacement if it was replaced
+func usedModule(m *debug.Module) *debug.Module {
+	if m.Replace != nil {
+		return m.Replace
+	}
+	return m
+}
+
+// Package URL of a go module
+func goPURL(path, version string) string {
+	if version == "" || version == "(devel)" {
+		return "pkg:golang/" + path
+	}
+	return "pkg:golang/" + path + "@" + version
+}
+
+// SPDX identifiers only allow letters, numbers, . and -
+func spdxID(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return "SPDXRef-Package-" + b.String()
+}
+
+// Random version 4 UUID
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// Get the sha256 of a file as hex
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CycloneDX 1.5 document, only what we fill in
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// SPDX 2.3 document, only what we fill in
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// Build the CycloneDX document of a binary
+func cycloneDX(name, version, digest string, bi *buildinfo.BuildInfo, main sbomModule, deps []sbomModule) cdxBOM {
+	var props []cdxProperty
+	for _, s := range bi.Settings {
+		if s.Key == "GOOS" || s.Key == "GOARCH" || s.Key == "GOARM" || s.Key == "CGO_ENABLED" {
+			props = append(props, cdxProperty{Name: "go:" + s.Key, Value: s.Value})
+		}
+	}
+
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: buildStart.Format(time.RFC3339),
+			Tools: cdxTools{Components: []cdxComponent{
+				{Type: "application", Name: me, Version: versionString()},
+			}},
+			Component: cdxComponent{
+				Type:       "application",
+				BOMRef:     main.PURL,
+				Name:       name,
+				Version:    version,
+				PURL:       main.PURL,
+				Hashes:     []cdxHash{{Alg: "SHA-256", Content: digest}},
+				Properties: props,
+			},
+		},
+	}
+
+	dependsOn := []string{}
+	for _, dep := range deps {
+		bom.Components = append(bom.Components, cdxComponent{
+			Type:    "library",
+			BOMRef:  dep.PURL,
+			Name:    dep.Path,
+			Version: dep.Version,
+			PURL:    dep.PURL,
+		})
+		dependsOn = append(dependsOn, dep.PURL)
+	}
+	bom.Dependencies = []cdxDependency{{Ref: main.PURL, DependsOn: dependsOn}}
+	return bom
+}
+
+// Build the SPDX document of a binary
+func spdx(name, version, digest string, main sbomModule, deps []sbomModule) spdxDocument {
+	pkg := func(m sbomModule) spdxPackage {
+		return spdxPackage{
+			Name:             m.Path,
+			SPDXID:           m.ID,
+			VersionInfo:      m.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{
+				{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: m.PURL},
+			},
+		}
+	}
+
+	mainPkg := pkg(main)
+	mainPkg.Name = name
+	mainPkg.VersionInfo = version
+	mainPkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: digest}}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + name + "-" + newUUID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  buildStart.Format(time.RFC3339),
+			Creators: []string{"Tool: " + me + "-" + versionString()},
+		},
+		Packages: []spdxPackage{mainPkg},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: main.ID},
+		},
+	}
+	for _, dep := range deps {
+		doc.Packages = append(doc.Packages, pkg(dep))
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID: main.ID, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: dep.ID,
+		})
+	}
+	return doc
+}
+
+// Version of go-xbuild-go itself
+func versionString() string {
+	return version.Get()
+}
+
+// Base name of the SBOM files of a binary, without .exe
+func sbomBase(binaryName string) string {
+	return strings.TrimSuffix(binaryName, ".exe")
+}
+
+// Write the CycloneDX and SPDX SBOMs of a built binary to the bin
+// directory. Returns the names of the files written.
+func writeSBOMs(config *Config, version, binaryName string) ([]string, error) {
+	bi, err := buildinfo.ReadFile(binaryName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build info of %s: %v", binaryName, err)
+	}
+	digest, err := sha256File(binaryName)
+	if err != nil {
+		return nil, err
+	}
+
+	main := sbomModule{Path: bi.Main.Path, Version: version}
+	if main.Path == "" {
+		main.Path = bi.Path
+	}
+	main.PURL = goPURL(main.Path, version)
+	main.ID = spdxID(main.Path)
+
+	// The go standard library is a dependency too
+	deps := []sbomModule{{
+		Path:    "stdlib",
+		Version: bi.GoVersion,
+		PURL:    goPURL("stdlib", bi.GoVersion),
+		ID:      spdxID("stdlib"),
+	}}
+	for _, dep := range bi.Deps {
+		m := usedModule(dep)
+		deps = append(deps, sbomModule{
+			Path:    m.Path,
+			Version: m.Version,
+			PURL:    goPURL(m.Path, m.Version),
+			ID:      spdxID(m.Path + "-" + m.Version),
+		})
+	}
+
+	name := config.ProjectName
+	docs := []struct {
+		file string
+		doc  interface{}
+	}{
+		{sbomBase(binaryName) + cycloneDXExt, cycloneDX(name, version, digest, bi, main, deps)},
+		{sbomBase(binaryName) + spdxExt, spdx(name, version, digest, main, deps)},
+	}
+
+	var files []string
+	for _, d := range docs {
+		data, err := json.MarshalIndent(d.doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(config.BinDir, d.file)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write SBOM %s: %v", path, err)
+		}
+		fmt.Printf("Created SBOM %s\n", d.file)
+		files = append(files, d.file)
+	}
+	return files, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Scoop manifest of a target, installing the windows zip archives of
+// the release
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ScoopConfig configures the Scoop manifest of a target. The project
+// level section is the default for all targets, a target's section
+// replaces it.
+type ScoopConfig struct {
+	Name          string          `json:"name"`           // manifest name, default output name of the target
+	Description   string          `json:"description"`    // default packages summary
+	Homepage      string          `json:"homepage"`       // default packages homepage or the origin repository
+	License       string          `json:"license"`        // default packages license
+	Checkver      json.RawMessage `json:"checkver"`       // default the GitHub releases of the origin remote
+	Bucket        string          `json:"bucket"`         // local clone of the bucket repository (optional)
+	Directory     string          `json:"directory"`      // directory of manifests in the bucket, default "bucket"
+	CommitMessage string          `json:"commit_message"` // templated, default "{{.Target}} {{.Version}}"
+	Skip          bool            `json:"skip"`           // no manifest for this target
+}
+
+// scoopManifest is the JSON manifest of a Scoop app
+type scoopManifest struct {
+	Version      string                       `json:"version"`
+	Description  string                       `json:"description"`
+	Homepage     string                       `json:"homepage,omitempty"`
+	License      string                       `json:"license,omitempty"`
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+	Checkver     json.RawMessage              `json:"checkver,omitempty"`
+	Autoupdate   *scoopAutoupdate             `json:"autoupdate,omitempty"`
+}
+
+// scoopArchitecture is the download of one architecture
+type scoopArchitecture struct {
+	URL        string      `json:"url"`
+	Hash       interface{} `json:"hash,omitempty"` // sha256, or {"url": ...} in autoupdate
+	ExtractDir string      `json:"extract_dir"`
+	Bin        [][]string  `json:"bin"` // binary and its shim name
+}
+
+// scoopAutoupdate has the architectures with $version in place of the
+// version
+type scoopAutoupdate struct {
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+}
+
+// Scoop architectures of windows GOARCH
+var scoopArchs = map[string]string{
+	"amd64": "64bit",
+	"386":   "32bit",
+	"arm64": "arm64",
+}
+
+// Write the Scoop manifests of the targets and commit them to the bucket
+func writeScoop(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	for _, target := range projectConfig.Targets {
+		sc := projectConfig.Scoop
+		if target.Scoop != nil {
+			sc = target.Scoop
+		}
+		if sc == nil || sc.Skip {
+			continue
+		}
+		name := sc.Name
+		if name == "" {
+			name = targetOutputName(target)
+		}
+		file := name + ".json"
+		if config.DryRun {
+			fmt.Printf("Would write Scoop manifest %s\n", file)
+			continue
+		}
+		manifest, err := scoopManifestOf(ctx, config, version, target, sc, name)
+		if err != nil {
+			return fmt.Errorf("target %s: scoop: %v", target.Name, err)
+		}
+		if manifest == nil {
+			fmt.Printf("No windows archives of %s, skipping Scoop manifest\n", target.Name)
+			continue
+		}
+		data, err := json.MarshalIndent(manifest, "", "    ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(config.BinDir, file)
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Created Scoop manifest %s\n", file)
+		config.Summary.add("scoop", file)
+
+		if sc.Bucket == "" {
+			continue
+		}
+		dir := sc.Directory
+		if dir == "" {
+			dir = "bucket"
+		}
+		files := map[string]string{filepath.Join(dir, file): path}
+		if err := commitManifests(ctx, config, version, name, sc.Bucket, sc.CommitMessage, files); err != nil {
+			return fmt.Errorf("target %s: scoop: %v", target.Name, err)
+		}
+	}
+	return nil
+}
+
+// The manifest of a target, nil if it has no windows archives
+func scoopManifestOf(ctx context.Context, config *Config, version string, target BuildTarget, sc *ScoopConfig, name string) (*scoopManifest, error) {
+	info := newManifestInfo(ctx, config, target, name, sc.Description, sc.Homepage, sc.License)
+	v := strings.TrimPrefix(version, "v")
+	manifest := &scoopManifest{
+		Version:      v,
+		Description:  info.Description,
+		Homepage:     info.Homepage,
+		License:      info.License,
+		Architecture: make(map[string]scoopArchitecture),
+		Checkver:     sc.Checkver,
+		Autoupdate:   &scoopAutoupdate{Architecture: make(map[string]scoopArchitecture)},
+	}
+	if manifest.Checkver == nil {
+		if repo := githubRepository(ctx); repo != "" {
+			manifest.Checkver, _ = json.Marshal(map[string]string{"github": "https://github.com/" + repo})
+		}
+	}
+
+	// $version is the version without the leading v, the autoupdate
+	// names are made with it in place of the version
+	versionVar := strings.TrimSuffix(version, v) + "$version"
+	for _, a := range targetArtifacts(config, targetOutputName(target)) {
+		arch, ok := scoopArchs[a.GOARCH]
+		if a.GOOS != "windows" || !ok {
+			continue
+		}
+		if _, ok := manifest.Architecture[arch]; ok {
+			continue
+		}
+		url, err := releaseURL(ctx, config, version, a, a.Archive)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := releaseSHA256(config.BinDir, a.Archive)
+		if err != nil {
+			return nil, err
+		}
+		extractDir := strings.TrimSuffix(a.Archive, ".zip")
+		bin := [][]string{{a.Binary, name}}
+		manifest.Architecture[arch] = scoopArchitecture{URL: url, Hash: sum, ExtractDir: extractDir, Bin: bin}
+
+		next := a
+		next.Archive = versionedName(a.Archive, a.Target, version, versionVar)
+		next.Binary = versionedName(a.Binary, a.Target, version, versionVar)
+		nextURL, err := releaseURL(ctx, config, versionVar, next, next.Archive)
+		if err != nil {
+			return nil, err
+		}
+		// the hash is looked up in the checksums file next to the archive,
+		// without one Scoop computes it after the download
+		var hash interface{}
+		if sumFile := sha256FileName(config, versionVar, next); sumFile != "" && path.Base(url) == a.Archive {
+			hash = map[string]string{"url": "$baseurl/" + sumFile}
+		}
+		manifest.Autoupdate.Architecture[arch] = scoopArchitecture{
+			URL:        nextURL,
+			Hash:       hash,
+			ExtractDir: strings.TrimSuffix(next.Archive, ".zip"),
+			Bin:        [][]string{{next.Binary, name}},
+		}
+	}
+	if len(manifest.Architecture) == 0 {
+		return nil, nil
+	}
+	return manifest, nil
+}
+
+// Put placeholder in place of the version of a file name of the build,
+// the names start with "<target>-<version>-"
+func versionedName(name, target, version, placeholder string) string {
+	prefix := target + "-" + version + "-"
+	if !strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return target + "-" + placeholder + "-" + strings.TrimPrefix(name, prefix)
+}
@@ -0,0 +1,290 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Detached signatures for the checksums files and optionally the
+// archives: minisign (.minisig), raw ed25519 (.sig) or gpg (.sig)
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/muquit/go-xbuild-go/pkg/minisign"
+)
+
+// Default environment variables for the signing key and its password
+const (
+	defaultSignKeyEnv      = "XBUILD_SIGN_KEY"
+	defaultSignPasswordEnv = "XBUILD_SIGN_PASSWORD"
+)
+
+// Extensions of the signature files
+const (
+	minisigExt = ".minisig"
+	sigExt     = ".sig"
+)
+
+// SignConfig configures detached signatures
+type SignConfig struct {
+	Backend     string `json:"backend"`      // "minisign" (default), "ed25519" or "gpg"
+	KeyFile     string `json:"key_file"`     // minisign secret key or PEM (PKCS#8) ed25519 key
+	KeyEnv      string `json:"key_env"`      // variable with the key if key_file is not set, default XBUILD_SIGN_KEY
+	PasswordEnv string `json:"password_env"` // variable with the key password or gpg passphrase, default XBUILD_SIGN_PASSWORD
+	GPGKey      string `json:"gpg_key"`      // gpg key id or user id, default key of gpg if empty
+	Archives    bool   `json:"archives"`     // sign every archive as well
+}
+
+// signer creates the signature files
+type signer struct {
+	config     SignConfig
+	minisign   *minisign.PrivateKey // minisign backend
+	ed25519    ed25519.PrivateKey   // ed25519 backend
+	gpg        string               // path of gpg
+	passphrase string               // gpg passphrase
+}
+
+// Check the sign section of the config file
+func checkSignConfig(c *SignConfig) error {
+	if c == nil {
+		return nil
+	}
+	switch c.Backend {
+	case "", "minisign", "ed25519", "gpg":
+	default:
+		return fmt.Errorf("sign: unknown backend %q (use minisign, ed25519 or gpg)", c.Backend)
+	}
+	if c.Backend == "gpg" && c.KeyFile != "" {
+		return fmt.Errorf("sign: key_file is not used by the gpg backend, use gpg_key")
+	}
+	return nil
+}
+
+// Only the combined and JSON checksums files are signed, sidecars alone
+// would leave no checksums signed
+func checkChecksumsSigning(config *Config) error {
+	c := config.Checksums
+	if config.Signer == nil || c == nil || !c.Sidecar || c.JSON {
+		return nil
+	}
+	return fmt.Errorf("sign: sidecar checksums files are not signed, add \"json\": true to checksums to sign a JSON checksums file")
+}
+
+// Load the signing key. baseDir is used for a relative key_file.
+func newSigner(c SignConfig, baseDir string) (*signer, error) {
+	if c.Backend == "" {
+		c.Backend = "minisign"
+	}
+	if c.KeyEnv == "" {
+		c.KeyEnv = defaultSignKeyEnv
+	}
+	if c.PasswordEnv == "" {
+		c.PasswordEnv = defaultSignPasswordEnv
+	}
+	s := &signer{config: c}
+	password := os.Getenv(c.PasswordEnv)
+
+	if c.Backend == "gpg" {
+		gpg, err := exec.LookPath("gpg")
+		if err != nil {
+			return nil, fmt.Errorf("sign: gpg not found in PATH")
+		}
+		s.gpg = gpg
+		s.passphrase = password
+		return s, nil
+	}
+
+	var key []byte
+	if c.KeyFile != "" {
+		path := c.KeyFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("sign: failed to read key file: %v", err)
+		}
+		key = data
+	} else {
+		key = []byte(os.Getenv(c.KeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("sign: no key_file and $%s is not set", c.KeyEnv)
+		}
+	}
+
+	if bytes.Contains(key, []byte("-----BEGIN")) {
+		if c.Backend == "minisign" {
+			return nil, fmt.Errorf("sign: PEM keys can only be used with the ed25519 backend")
+		}
+		k, err := parsePEMKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s.ed25519 = k
+		return s, nil
+	}
+
+	k, err := minisign.ParsePrivateKey(key, []byte(password))
+	if err != nil {
+		return nil, fmt.Errorf("sign: %v", err)
+	}
+	if c.Backend == "ed25519" {
+		s.ed25519 = k.Key
+	} else {
+		s.minisign = k
+	}
+	return s, nil
+}
+
+// Parse a PEM encoded PKCS#8 ed25519 private key (openssl genpkey -algorithm ed25519)
+func parsePEMKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("sign: invalid PEM key")
+	}
+	if block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("sign: unsupported PEM key type %q, need an unencrypted PKCS#8 ed25519 key", block.Type)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sign: invalid PEM key: %v", err)
+	}
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("sign: PEM key is not an ed25519 key")
+	}
+	return k, nil
+}
+
+// Extension of the signature files
+func (s *signer) ext() string {
+	if s.config.Backend == "minisign" {
+		return minisigExt
+	}
+	return sigExt
+}
+
+// Write the detached signature of path, returns the signature file
+func (s *signer) sign(ctx context.Context, path string) (string, error) {
+	sigFile := path + s.ext()
+	switch {
+	case s.minisign != nil:
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		sig, err := minisign.Sign(s.minisign, f, filepath.Base(path))
+		if err != nil {
+			return "", err
+		}
+		return sigFile, os.WriteFile(sigFile, sig, 0644)
+	case s.ed25519 != nil:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		sig := ed25519.Sign(s.ed25519, data)
+		return sigFile, os.WriteFile(sigFile, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+	}
+
+	args := []string{"--batch", "--yes", "--detach-sign"}
+	if s.config.GPGKey != "" {
+		args = append(args, "--local-user", s.config.GPGKey)
+	}
+	if s.passphrase != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "0")
+	}
+	args = append(args, "--output", sigFile, path)
+	cmd := exec.CommandContext(ctx, s.gpg, args...)
+	cmd.Stdin = strings.NewReader(s.passphrase)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(sigFile)
+		return "", fmt.Errorf("gpg failed: %v\n%s", err, out)
+	}
+	return sigFile, nil
+}
+
+// Return the detached signature of data, used to sign package headers
+func (s *signer) signData(ctx context.Context, data []byte) ([]byte, error) {
+	f, err := os.CreateTemp("", "xbuild-sign-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	sigFile, err := s.sign(ctx, f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(sigFile)
+	return os.ReadFile(sigFile)
+}
+
+// Sign the checksums files of a target and, if configured, its archives
+func signTarget(ctx context.Context, config *Config, version string) error {
+	s := config.Signer
+	if s == nil {
+		return nil
+	}
+	var files []string
+	if !projectChecksums(config) {
+		files = checksumsFiles(config, version)
+	}
+	if s.config.Archives && config.Artifacts != nil {
+		for _, a := range config.Artifacts.Artifacts {
+			if a.Target == config.ProjectName {
+				files = append(files, filepath.Join(config.BinDir, a.Archive))
+			}
+		}
+	}
+	return signFiles(ctx, config, files)
+}
+
+// Write the signatures of files
+func signFiles(ctx context.Context, config *Config, files []string) error {
+	s := config.Signer
+	if s == nil {
+		return nil
+	}
+	for _, file := range files {
+		if config.DryRun {
+			fmt.Printf("Would sign %s (%s)\n", filepath.Base(file), s.config.Backend)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sigFile, err := s.sign(ctx, file)
+		if err != nil {
+			return fmt.Errorf("failed to sign %s: %v", filepath.Base(file), err)
+		}
+		fmt.Printf("Signed %s\n", filepath.Base(file))
+		config.Summary.add("sign", filepath.Base(sigFile))
+	}
+	return nil
+}
+
+// Remove the signatures of a file left from a previous build
+func removeSignatures(file string) error {
+	for _, ext := range []string{minisigExt, sigExt} {
+		if err := os.Remove(file + ext); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old signature: %v", err)
+		}
+	}
+	return nil
+}
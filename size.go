@@ -0,0 +1,213 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Binary size report: size of every binary and archive with the delta
+// from the previous artifacts.json, the largest packages of a binary
+// and an optional max_size per target
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SizeReportConfig configures the size report
+type SizeReportConfig struct {
+	TopPackages int `json:"top_packages"` // show the largest packages of each binary (optional)
+}
+
+// Size units for max_size
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// Parse a size like 12MB, 8MiB or 1500000
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// Format a size in bytes for humans
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20 || n <= -(1<<20):
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10 || n <= -(1<<10):
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Format the change from the previous size
+func formatDelta(now, before int64) string {
+	if before == 0 {
+		return "new"
+	}
+	delta := now - before
+	if delta == 0 {
+		return "="
+	}
+	sign := ""
+	if delta > 0 {
+		sign = "+"
+	}
+	return fmt.Sprintf("%s%s (%s%.1f%%)", sign, formatSize(delta), sign, float64(delta)*100/float64(before))
+}
+
+// Get the size of the built binary and make sure it is within max_size of
+// the target
+func checkBinarySize(config *Config, binaryName string) (int64, error) {
+	fi, err := os.Stat(binaryName)
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	if config.Target != nil && config.Target.MaxSize != "" {
+		max, _ := parseSize(config.Target.MaxSize) // checked on load
+		if size > max {
+			err := fmt.Errorf("%s is %s, larger than max_size %s", binaryName, formatSize(size), config.Target.MaxSize)
+			config.Summary.addResult("size", binaryName, err)
+			return size, err
+		}
+	}
+	return size, nil
+}
+
+// packageSize is the size of the code (and data, if known) of a package
+type packageSize struct {
+	Name string
+	Size int64
+}
+
+// Get the sizes of the packages in a binary. The ELF symbol table has the
+// size of every symbol, if it was stripped (-s) the size of the functions
+// is taken from the go pclntab instead.
+func packageSizes(binaryName string) ([]packageSize, error) {
+	if ef, err := elf.Open(binaryName); err == nil {
+		defer ef.Close()
+		if syms, err := ef.Symbols(); err == nil && len(syms) > 0 {
+			sizes := make(map[string]int64)
+			for _, s := range syms {
+				sizes[symPackage(s.Name)] += int64(s.Size)
+			}
+			return sortPackageSizes(sizes), nil
+		}
+		pcln, text := ef.Section(".gopclntab"), ef.Section(".text")
+		if pcln == nil || text == nil {
+			return nil, fmt.Errorf("no go pclntab found")
+		}
+		return pclntabSizes(pcln.Data, text.Addr)
+	}
+	if mf, err := macho.Open(binaryName); err == nil {
+		defer mf.Close()
+		pcln, text := mf.Section("__gopclntab"), mf.Section("__text")
+		if pcln == nil || text == nil {
+			return nil, fmt.Errorf("no go pclntab found")
+		}
+		return pclntabSizes(pcln.Data, text.Addr)
+	}
+	return nil, fmt.Errorf("package sizes are only available for ELF and Mach-O binaries")
+}
+
+// Get the sizes of the functions of each package from the go pclntab
+func pclntabSizes(pclntab func() ([]byte, error), textAddr uint64) ([]packageSize, error) {
+	data, err := pclntab()
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, textAddr))
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for _, fn := range table.Funcs {
+		sizes[symPackage(fn.Name)] += int64(fn.End - fn.Entry)
+	}
+	return sortPackageSizes(sizes), nil
+}
+
+// Get the package of a symbol
+func symPackage(name string) string {
+	pkg := (&gosym.Sym{Name: name}).PackageName()
+	if pkg == "" {
+		return "(other)"
+	}
+	return pkg
+}
+
+// Sort packages by size, largest first
+func sortPackageSizes(sizes map[string]int64) []packageSize {
+	list := make([]packageSize, 0, len(sizes))
+	for name, size := range sizes {
+		if size > 0 {
+			list = append(list, packageSize{Name: name, Size: size})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Size != list[j].Size {
+			return list[i].Size > list[j].Size
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Print the largest packages of a binary
+func printPackageSizes(config *Config, binaryName string) {
+	if config.SizeReport == nil || config.SizeReport.TopPackages <= 0 {
+		return
+	}
+	list, err := packageSizes(binaryName)
+	if err != nil {
+		fmt.Printf("Largest packages of %s: not available: %v\n", binaryName, err)
+		return
+	}
+	if len(list) > config.SizeReport.TopPackages {
+		list = list[:config.SizeReport.TopPackages]
+	}
+	fmt.Printf("Largest packages of %s:\n", binaryName)
+	for _, pkg := range list {
+		fmt.Printf("  %12s  %s\n", formatSize(pkg.Size), pkg.Name)
+	}
+}
+
+// Print the size of every binary and archive of this build with the
+// change from the previous build
+func printSizeReport(config *Config, previous *artifactList) {
+	if config.SizeReport == nil || config.Artifacts == nil || len(config.Artifacts.Artifacts) == 0 {
+		return
+	}
+	fmt.Printf("\nSize report:\n")
+	fmt.Printf("  %-40s %12s %-22s %12s %s\n", "binary", "size", "change", "archive", "change")
+	for _, a := range config.Artifacts.Artifacts {
+		before, _ := previous.find(a.key())
+		fmt.Printf("  %-40s %12s %-22s %12s %s\n",
+			a.Binary,
+			formatSize(a.BinarySize), formatDelta(a.BinarySize, before.BinarySize),
+			formatSize(a.ArchiveSize), formatDelta(a.ArchiveSize, before.ArchiveSize))
+	}
+}
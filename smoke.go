@@ -0,0 +1,102 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Smoke test of built binaries: run the binary with some arguments
+// (e.g. --version) when it can run on this host, directly or through
+// an emulator such as qemu-aarch64 for foreign linux architectures
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// SmokeTest configures running the built binary of a target
+type SmokeTest struct {
+	Args      []string          `json:"args"`      // arguments, e.g. ["--version"]
+	Expect    string            `json:"expect"`    // regexp the output must match (optional)
+	Timeout   string            `json:"timeout"`   // default: 30s
+	Emulators map[string]string `json:"emulators"` // linux GOARCH to emulator, e.g. "arm64": "qemu-aarch64" (optional)
+}
+
+// Default timeout of a smoke test
+const smokeTimeout = 30 * time.Second
+
+// Validate the smoke test of a target
+func checkSmokeTest(where string, st *SmokeTest) error {
+	if st == nil {
+		return nil
+	}
+	if _, err := regexp.Compile(st.Expect); err != nil {
+		return fmt.Errorf("%s: invalid smoke_test expect: %v", where, err)
+	}
+	if st.Timeout != "" {
+		if _, err := time.ParseDuration(st.Timeout); err != nil {
+			return fmt.Errorf("%s: invalid smoke_test timeout %q: %v", where, st.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// Get the command to run a binary of the platform on this host: the binary
+// itself if the platform is the host's, an emulator for linux if one is
+// configured for the GOARCH. Empty if it can't be run.
+func smokeCommand(st *SmokeTest, p platform, binary string) []string {
+	if p.GOOS == runtime.GOOS && p.GOARCH == runtime.GOARCH {
+		return []string{binary}
+	}
+	if p.GOOS == "linux" && runtime.GOOS == "linux" {
+		if emulator := st.Emulators[p.GOARCH]; emulator != "" {
+			return []string{emulator, binary}
+		}
+	}
+	return nil
+}
+
+// Run the smoke test of the target on the built binary. The build fails
+// if the binary exits non-zero or its output does not match.
+func smokeTest(ctx context.Context, config *Config, binaryName string, p platform) error {
+	if config.Target == nil || config.Target.SmokeTest == nil {
+		return nil
+	}
+	st := config.Target.SmokeTest
+
+	command := smokeCommand(st, p, absBinary(binaryName))
+	if command == nil {
+		fmt.Printf("Skipping smoke test of %s, can't run %s binaries on this host\n", binaryName, p.Label)
+		return nil
+	}
+	command = append(command, st.Args...)
+
+	if config.DryRun {
+		fmt.Printf("  smoke test: %s\n", quoteArgs(command))
+		return nil
+	}
+
+	timeout := smokeTimeout
+	if st.Timeout != "" {
+		timeout, _ = time.ParseDuration(st.Timeout) // checked on load
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fmt.Printf("Smoke testing %s: %s\n", binaryName, quoteArgs(command))
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		err = fmt.Errorf("timed out after %s", timeout)
+	case err != nil:
+		err = fmt.Errorf("%v, output:\n%s", err, out)
+	case st.Expect != "" && !regexp.MustCompile(st.Expect).Match(out):
+		err = fmt.Errorf("output does not match %q:\n%s", st.Expect, out)
+	}
+	config.Summary.addResult("smoke", binaryName, err)
+	if err != nil {
+		return fmt.Errorf("smoke test of %s failed: %v", binaryName, err)
+	}
+	return nil
+}
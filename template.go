@@ -0,0 +1,76 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Template expansion of values in build-config.json
+// e.g. "CC": "zig cc -target {{.GOARCH}}-linux-gnu"
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Time the run started, used as {{.Date}}
+var buildStart = time.Now().UTC()
+
+// cached output of git rev-parse HEAD
+var commit *string
+
+// templateData is what can be used as {{.Name}} in templated config values
+type templateData struct {
+	Version string // from VERSION file
+	Target  string // output name of the target
+	GOOS    string
+	GOARCH  string
+	GOARM   string // empty except for arm builds
+	Commit  string // git commit hash, empty if not a git repository
+	Date    string // build start time, RFC3339
+	Binary  string // path of the built binary, after_each hooks only
+	File    string // file name of a release asset, release_url only
+}
+
+// newTemplateData returns the template values for a target and platform
+func newTemplateData(ctx context.Context, config *Config, version string, p platform) templateData {
+	return templateData{
+		Version: version,
+		Target:  config.ProjectName,
+		GOOS:    p.GOOS,
+		GOARCH:  p.GOARCH,
+		GOARM:   p.GOARM,
+		Commit:  gitCommit(ctx),
+		Date:    buildStart.Format(time.RFC3339),
+	}
+}
+
+// Expand {{...}} in s. Strings without templates are returned as is
+func expandTemplate(s string, data templateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("value").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Get the current git commit hash, empty if it can't be determined
+func gitCommit(ctx context.Context) string {
+	if commit == nil {
+		out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output()
+		c := ""
+		if err == nil {
+			c = strings.TrimSpace(string(out))
+		}
+		commit = &c
+	}
+	return *commit
+}
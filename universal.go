@@ -0,0 +1,299 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// macOS universal binaries: the darwin/amd64 and darwin/arm64 binaries
+// of a target merged into a fat Mach-O and archived as darwin-universal
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Platform name of the universal archive
+const universalPlatform = "darwin-universal"
+
+// UniversalConfig configures the macOS universal binary of a target. The
+// project level section is the default for all targets, a target's
+// section replaces it.
+type UniversalConfig struct {
+	Replace bool `json:"replace"` // remove the darwin-amd64 and darwin-arm64 archives
+	Skip    bool `json:"skip"`    // no universal binary for this target
+}
+
+// Merge the darwin binaries of the target into a universal binary and
+// archive it, after the platforms of the target are built
+func buildUniversal(ctx context.Context, config *Config, version string) (err error) {
+	u := config.Universal
+	if u == nil || u.Skip {
+		return nil
+	}
+	platforms, err := readPlatforms(config)
+	if err != nil {
+		return err
+	}
+	var hasAmd64, hasArm64 bool
+	for _, p := range platforms {
+		hasAmd64 = hasAmd64 || p.GOOS == "darwin" && p.GOARCH == "amd64"
+		hasArm64 = hasArm64 || p.GOOS == "darwin" && p.GOARCH == "arm64"
+	}
+	if !hasAmd64 || !hasArm64 {
+		fmt.Printf("darwin/amd64 and darwin/arm64 are not both built, skipping universal binary\n")
+		return nil
+	}
+
+	distDir := fmt.Sprintf("%s-%s-%s.d", config.ProjectName, version, universalPlatform)
+	binaryName := fmt.Sprintf("%s-%s-%s", config.ProjectName, version, universalPlatform)
+	fmt.Printf("\n> Building %s universal binary\n", config.ProjectName)
+	if config.DryRun {
+		fmt.Printf("Would merge darwin/amd64 and darwin/arm64 into %s\n", binaryName)
+		if u.Replace {
+			fmt.Printf("Would remove the darwin-amd64 and darwin-arm64 archives\n")
+		}
+		return nil
+	}
+
+	artifacts := targetArtifacts(config, config.ProjectName)
+	amd64, ok := findArtifact(artifacts, "darwin", "amd64", "")
+	if !ok {
+		return fmt.Errorf("universal binary: no darwin/amd64 archive")
+	}
+	arm64, ok := findArtifact(artifacts, "darwin", "arm64", "")
+	if !ok {
+		return fmt.Errorf("universal binary: no darwin/arm64 archive")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "xbuild-universal-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	defer func() {
+		if err != nil {
+			removePartial(config, version, binaryName, distDir, nil)
+		}
+	}()
+
+	// The universal archive has the files of the arm64 archive with the
+	// universal binary in place of the arm64 one
+	if err := extractTarGz(filepath.Join(config.BinDir, arm64.Archive), tmp); err != nil {
+		return fmt.Errorf("universal binary: %s: %v", arm64.Archive, err)
+	}
+	armDir := filepath.Join(tmp, strings.TrimSuffix(arm64.Archive, ".tar.gz"))
+	armBinary, err := os.ReadFile(filepath.Join(armDir, arm64.Binary))
+	if err != nil {
+		return fmt.Errorf("universal binary: %v", err)
+	}
+	amdBinary, err := readTarGzFile(filepath.Join(config.BinDir, amd64.Archive), amd64.Binary)
+	if err != nil {
+		return fmt.Errorf("universal binary: %s: %v", amd64.Archive, err)
+	}
+	fat, err := machoUniversal([][]byte{amdBinary, armBinary})
+	if err != nil {
+		return fmt.Errorf("universal binary: %v", err)
+	}
+
+	dir := filepath.Join(tmp, distDir)
+	if err := os.Rename(armDir, dir); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, arm64.Binary)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, binaryName), fat, 0755); err != nil {
+		return fmt.Errorf("universal binary: %v", err)
+	}
+	fmt.Printf("Merged %s and %s into %s\n", amd64.Binary, arm64.Binary, binaryName)
+
+	archiveName, err := createArchive(config, version, dir, "darwin")
+	if err != nil {
+		return err
+	}
+	if err := removeSignatures(filepath.Join(config.BinDir, archiveName)); err != nil {
+		return err
+	}
+	archiveInfo, err := os.Stat(filepath.Join(config.BinDir, archiveName))
+	if err != nil {
+		return err
+	}
+
+	// The SBOMs of the per-arch binaries describe the slices, they stay
+	// with the universal archive if the per-arch archives are removed
+	var sboms []string
+	if u.Replace {
+		for _, a := range []artifact{amd64, arm64} {
+			if err := removeArchive(config, version, a); err != nil {
+				return fmt.Errorf("universal binary: %v", err)
+			}
+			sboms = append(sboms, a.SBOMs...)
+		}
+	}
+
+	config.Summary.add("archive", archiveName)
+	config.Artifacts.add(artifact{
+		Target:      config.ProjectName,
+		Platform:    universalPlatform,
+		GOOS:        "darwin",
+		GOARCH:      "universal",
+		Binary:      binaryName,
+		BinarySize:  int64(len(fat)),
+		Archive:     archiveName,
+		ArchiveSize: archiveInfo.Size(),
+		SBOMs:       sboms,
+	})
+	return nil
+}
+
+// Remove an archive of this run with its checksums and signatures
+func removeArchive(config *Config, version string, a artifact) error {
+	path := filepath.Join(config.BinDir, a.Archive)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := removeSignatures(path); err != nil {
+		return err
+	}
+	if err := dropChecksums(config, version, a.Archive); err != nil {
+		return err
+	}
+	config.Artifacts.remove(a.key())
+	config.Summary.remove("archive", a.Archive)
+	fmt.Printf("Removed %s\n", a.Archive)
+	return nil
+}
+
+// Merge thin Mach-O binaries into a universal binary. The slices are
+// aligned to 16K for arm64 and 4K for the others, as lipo does.
+func machoUniversal(binaries [][]byte) ([]byte, error) {
+	type slice struct {
+		cpu, subCpu uint32
+		align       uint32 // power of 2
+		data        []byte
+	}
+	var slices []slice
+	for _, data := range binaries {
+		f, err := macho.NewFile(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("not a thin Mach-O binary: %v", err)
+		}
+		s := slice{cpu: uint32(f.Cpu), subCpu: f.SubCpu, align: 12, data: data}
+		if f.Cpu == macho.CpuArm64 {
+			s.align = 14
+		}
+		for _, other := range slices {
+			if other.cpu == s.cpu {
+				return nil, fmt.Errorf("two binaries for %v", f.Cpu)
+			}
+		}
+		slices = append(slices, s)
+	}
+
+	// fat_header and fat_arch are big endian
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, []uint32{macho.MagicFat, uint32(len(slices))})
+	offset := uint32(8 + 20*len(slices))
+	offsets := make([]uint32, len(slices))
+	for i, s := range slices {
+		offset = (offset + 1<<s.align - 1) &^ (1<<s.align - 1)
+		offsets[i] = offset
+		binary.Write(&b, binary.BigEndian, []uint32{s.cpu, s.subCpu, offset, uint32(len(s.data)), s.align})
+		offset += uint32(len(s.data))
+	}
+	for i, s := range slices {
+		b.Write(make([]byte, int(offsets[i])-b.Len()))
+		b.Write(s.data)
+	}
+	return b.Bytes(), nil
+}
+
+// Extract a tar.gz archive to a directory
+func extractTarGz(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return extractTar(gz, dir)
+}
+
+// Extract the directories and regular files of a tar stream to a
+// directory
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("unsafe path %s", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Read a file of a tar.gz archive by its base name
+func readTarGzFile(archive, name string) ([]byte, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
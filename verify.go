@@ -0,0 +1,428 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// verify subcommand: check the checksums, signatures and archives in
+// an output directory or a directory of downloaded release assets
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/muquit/go-xbuild-go/pkg/minisign"
+)
+
+// verifier holds the options of the verify subcommand
+type verifier struct {
+	dir           string
+	key           *minisign.PublicKey // minisign or ed25519 public key, nil if not given
+	ignoreMissing bool
+	summary       *buildSummary
+}
+
+// Run the verify subcommand with its arguments
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := fs.String("key", "", "minisign public key or PEM ed25519 public key to check .minisig/.sig signatures")
+	ignoreMissing := fs.Bool("ignore-missing", false, "Don't fail for files in the checksums files that are not present")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify [options] [directory]\n\n", me)
+		fmt.Fprintf(fs.Output(), "Check the checksums, signatures and archives in directory (default ./bin)\n\n")
+		fmt.Fprintf(fs.Output(), "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("only one directory can be verified")
+	}
+
+	v := &verifier{dir: "bin", ignoreMissing: *ignoreMissing, summary: &buildSummary{}}
+	if fs.NArg() == 1 {
+		v.dir = fs.Arg(0)
+	}
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %v", err)
+		}
+		v.key, err = parsePublicKey(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(v.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	artifacts, err := loadArtifacts(v.dir)
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch {
+		case strings.HasSuffix(name, "-checksums.txt"):
+			v.verifyChecksums(name, checksumsFileAlgorithm(name))
+			v.verifySignature(ctx, name, v.key != nil)
+		case strings.HasSuffix(name, "-checksums.json"):
+			v.verifyChecksumsJSON(name)
+			v.verifySignature(ctx, name, v.key != nil)
+		case strings.HasSuffix(name, provenanceExt):
+			v.verifyProvenance(name)
+			v.verifySignature(ctx, name, v.key != nil)
+		case isSidecar(name):
+			v.verifyChecksums(name, strings.TrimPrefix(filepath.Ext(name), "."))
+		case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".zip"):
+			v.summary.addResult("archive", name, verifyArchive(filepath.Join(v.dir, name), expectedBinary(name, artifacts)))
+			v.verifySignature(ctx, name, false)
+		default:
+			continue
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("no checksums files or archives found in %s", v.dir)
+	}
+
+	fmt.Println()
+	v.summary.print()
+	if n := v.summary.failures(); n > 0 {
+		return fmt.Errorf("verification failed: %d problem(s)", n)
+	}
+	return nil
+}
+
+// Parse a minisign public key or a PEM (PKIX) ed25519 public key
+func parsePublicKey(data []byte) (*minisign.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM public key: %v", err)
+		}
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("PEM public key is not an ed25519 key")
+		}
+		return &minisign.PublicKey{Key: k}, nil
+	}
+	k, err := minisign.ParsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Algorithm of a combined checksums file from its name,
+// <project>-<version>-<algorithm>-checksums.txt, sha256 if there is none
+func checksumsFileAlgorithm(name string) string {
+	base := strings.TrimSuffix(name, "-checksums.txt")
+	for _, algorithm := range checksumAlgorithms {
+		if strings.HasSuffix(base, "-"+algorithm) {
+			return algorithm
+		}
+	}
+	return "sha256"
+}
+
+// Recompute the checksum of a file and compare it with want
+func (v *verifier) verifyChecksum(name, algorithm, want string) {
+	sums, _, err := fileChecksums(filepath.Join(v.dir, name), []string{algorithm})
+	if os.IsNotExist(err) && v.ignoreMissing {
+		return
+	}
+	if err == nil && sums[algorithm] != strings.ToLower(want) {
+		err = fmt.Errorf("%s checksum mismatch", algorithm)
+	}
+	if err != nil {
+		fmt.Printf("%s: %v\n", name, err)
+	}
+	v.summary.addResult(algorithm, name, err)
+}
+
+// Recompute the checksum of every file listed in a checksums file
+// or sidecar file in sha256sum format
+func (v *verifier) verifyChecksums(checksumFile, algorithm string) {
+	f, err := os.Open(filepath.Join(v.dir, checksumFile))
+	if err != nil {
+		v.summary.addResult(algorithm, checksumFile, err)
+		return
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		lines++
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			fmt.Printf("%s: invalid line: %s\n", checksumFile, line)
+			v.summary.addResult(algorithm, checksumFile, errors.New("invalid line"))
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		v.verifyChecksum(name, algorithm, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		v.summary.addResult(algorithm, checksumFile, err)
+	} else if lines == 0 {
+		v.summary.addResult(algorithm, checksumFile, errors.New("empty checksums file"))
+	}
+}
+
+// Recompute every checksum listed in a JSON checksums file
+func (v *verifier) verifyChecksumsJSON(checksumFile string) {
+	data, err := os.ReadFile(filepath.Join(v.dir, checksumFile))
+	var list checksumsJSON
+	if err == nil {
+		err = json.Unmarshal(data, &list)
+	}
+	if err == nil && len(list.Files) == 0 {
+		err = errors.New("empty checksums file")
+	}
+	if err != nil {
+		fmt.Printf("%s: %v\n", checksumFile, err)
+		v.summary.addResult("json", checksumFile, err)
+		return
+	}
+	for _, entry := range list.Files {
+		for _, algorithm := range checksumAlgorithms {
+			if want, ok := entry.Checksums[algorithm]; ok {
+				v.verifyChecksum(entry.Name, algorithm, want)
+			}
+		}
+	}
+}
+
+// Recompute the sha256 of the subjects of the provenance statements
+func (v *verifier) verifyProvenance(provenanceFile string) {
+	data, err := os.ReadFile(filepath.Join(v.dir, provenanceFile))
+	if err != nil {
+		v.summary.addResult("provenance", provenanceFile, err)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var statement inTotoStatement
+		err := json.Unmarshal([]byte(line), &statement)
+		if err == nil && statement.Type != inTotoStatementType {
+			err = fmt.Errorf("not an in-toto statement")
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", provenanceFile, err)
+			v.summary.addResult("provenance", provenanceFile, err)
+			return
+		}
+		for _, subject := range statement.Subject {
+			v.verifyChecksum(subject.Name, "sha256", subject.Digest["sha256"])
+		}
+	}
+}
+
+// Check the .minisig or .sig signature of a file, if there is one. If
+// required, a missing signature is a failure.
+func (v *verifier) verifySignature(ctx context.Context, name string, required bool) {
+	file := filepath.Join(v.dir, name)
+	for _, ext := range []string{minisigExt, sigExt} {
+		sig, err := os.ReadFile(file + ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = v.checkSignature(ctx, file, ext, sig)
+		}
+		if errors.Is(err, errNoKey) {
+			fmt.Printf("%s: not checked, no public key given with -key\n", name+ext)
+			return
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", name+ext, err)
+		}
+		v.summary.addResult("signature", name+ext, err)
+		return
+	}
+	if required {
+		fmt.Printf("%s: no signature\n", name)
+		v.summary.addResult("signature", name, errors.New("no signature"))
+	}
+}
+
+var errNoKey = errors.New("no public key")
+
+// Verify one signature file. A .sig is either a base64 ed25519 signature
+// or a gpg signature, which is checked with the local gpg and its keyring.
+func (v *verifier) checkSignature(ctx context.Context, file, ext string, sig []byte) error {
+	if ext == minisigExt {
+		if v.key == nil {
+			return errNoKey
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = minisign.Verify(v.key, f, sig)
+		return err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err == nil && len(raw) == ed25519.SignatureSize {
+		if v.key == nil {
+			return errNoKey
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(v.key.Key, data, raw) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+		return nil
+	}
+
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		return fmt.Errorf("gpg signature, but gpg not found in PATH")
+	}
+	out, err := exec.CommandContext(ctx, gpg, "--batch", "--verify", file+ext, file).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gpg: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// Name of the binary expected in an archive, from artifacts.json if it
+// lists the archive, from the naming scheme otherwise
+func expectedBinary(archive string, artifacts *artifactList) string {
+	if artifacts != nil {
+		for _, a := range artifacts.Artifacts {
+			if a.Archive == archive {
+				return a.Binary
+			}
+		}
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(archive, ".tar.gz"), ".zip")
+	binary := strings.TrimSuffix(base, ".d")
+	if strings.HasSuffix(archive, ".zip") {
+		binary += ".exe"
+	}
+	return binary
+}
+
+// Read every entry of a tar.gz or zip archive and check that the binary
+// is in it (executable for tar.gz)
+func verifyArchive(archive, binary string) error {
+	var found bool
+	var err error
+	if strings.HasSuffix(archive, ".zip") {
+		found, err = verifyZip(archive, binary)
+	} else {
+		found, err = verifyTarGz(archive, binary)
+	}
+	if err == nil && !found {
+		err = fmt.Errorf("binary %s not found", binary)
+	}
+	if err != nil {
+		fmt.Printf("%s: %v\n", filepath.Base(archive), err)
+	}
+	return err
+}
+
+func verifyTarGz(archive, binary string) (bool, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	tr := tar.NewReader(gz)
+	found := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return false, fmt.Errorf("%s: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == binary {
+			if header.Mode&0111 == 0 {
+				return false, fmt.Errorf("binary %s is not executable", header.Name)
+			}
+			found = true
+		}
+	}
+	// read to the end so that the gzip checksum is verified
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+func verifyZip(archive, binary string) (bool, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+
+	found := false
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		// the CRC is checked when the entry is read to the end
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		if !file.FileInfo().IsDir() && path.Base(file.Name) == binary {
+			found = true
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// winget manifests of a target: version, installer and default locale
+// YAML files installing the windows zip archives of the release as
+// portable apps
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Version of the winget manifest schema
+const wingetManifestVersion = "1.6.0"
+
+// WingetConfig configures the winget manifests of a target. The project
+// level section is the default for all targets, a target's section
+// replaces it.
+type WingetConfig struct {
+	Publisher         string `json:"publisher"`          // required, e.g. "Jane Doe"
+	PackageIdentifier string `json:"package_identifier"` // default <Publisher>.<name> without spaces
+	Name              string `json:"name"`               // package name, default output name of the target
+	Description       string `json:"description"`        // default packages summary
+	Homepage          string `json:"homepage"`           // default packages homepage or the origin repository
+	License           string `json:"license"`            // required, default packages license
+	Repo              string `json:"repo"`               // local clone of the manifests repository (optional)
+	CommitMessage     string `json:"commit_message"`     // templated, default "{{.Target}} {{.Version}}"
+	Skip              bool   `json:"skip"`               // no manifests for this target
+}
+
+// winget architectures of windows GOARCH
+var wingetArchs = map[string]string{
+	"amd64": "x64",
+	"386":   "x86",
+	"arm64": "arm64",
+}
+
+// Check the winget section of a target
+func checkWingetConfig(name string, c *WingetConfig, packages *PackagesConfig) error {
+	if c == nil || c.Skip {
+		return nil
+	}
+	if c.Publisher == "" {
+		return fmt.Errorf("%s: winget: publisher is required", name)
+	}
+	if c.License == "" && (packages == nil || packages.License == "") {
+		return fmt.Errorf("%s: winget: license is required", name)
+	}
+	return nil
+}
+
+// Write the winget manifests of the targets and commit them to the
+// manifests repository
+func writeWinget(ctx context.Context, config *Config, version string) error {
+	projectConfig := config.ProjectConfig
+	for _, target := range projectConfig.Targets {
+		wc := projectConfig.Winget
+		if target.Winget != nil {
+			wc = target.Winget
+		}
+		if wc == nil || wc.Skip {
+			continue
+		}
+		name := wc.Name
+		if name == "" {
+			name = targetOutputName(target)
+		}
+		id := wc.PackageIdentifier
+		if id == "" {
+			id = strings.ReplaceAll(wc.Publisher+"."+name, " ", "")
+		}
+		if config.DryRun {
+			fmt.Printf("Would write winget manifests %s.*\n", id)
+			continue
+		}
+		manifests, err := wingetManifests(ctx, config, version, target, wc, id, name)
+		if err != nil {
+			return fmt.Errorf("target %s: winget: %v", target.Name, err)
+		}
+		if manifests == nil {
+			fmt.Printf("No windows archives of %s, skipping winget manifests\n", target.Name)
+			continue
+		}
+
+		// manifests/<first letter>/<publisher>/<name>/<version> in the repository
+		v := strings.TrimPrefix(version, "v")
+		dir := filepath.Join(append([]string{"manifests", strings.ToLower(id[:1])}, append(strings.Split(id, "."), v)...)...)
+		files := make(map[string]string)
+		for _, m := range manifests {
+			path := filepath.Join(config.BinDir, m.file)
+			if err := os.WriteFile(path, []byte(m.data), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %v", path, err)
+			}
+			fmt.Printf("Created winget manifest %s\n", m.file)
+			config.Summary.add("winget", m.file)
+			files[filepath.Join(dir, m.file)] = path
+		}
+
+		if wc.Repo == "" {
+			continue
+		}
+		if err := commitManifests(ctx, config, version, name, wc.Repo, wc.CommitMessage, files); err != nil {
+			return fmt.Errorf("target %s: winget: %v", target.Name, err)
+		}
+	}
+	return nil
+}
+
+// wingetManifest is a manifest file and its content
+type wingetManifest struct {
+	file string
+	data string
+}
+
+// The version, installer and default locale manifests of a target, nil if
+// it has no windows archives
+func wingetManifests(ctx context.Context, config *Config, version string, target BuildTarget, wc *WingetConfig, id, name string) ([]wingetManifest, error) {
+	info := newManifestInfo(ctx, config, target, name, wc.Description, wc.Homepage, wc.License)
+	v := strings.TrimPrefix(version, "v")
+	header := func(b *strings.Builder, schema string) {
+		fmt.Fprintf(b, "# Generated by %s %s, do not edit\n", me, versionString())
+		fmt.Fprintf(b, "# yaml-language-server: $schema=https://aka.ms/winget-manifest.%s.%s.schema.json\n\n", schema, wingetManifestVersion)
+		fmt.Fprintf(b, "PackageIdentifier: %s\n", yamlString(id))
+		fmt.Fprintf(b, "PackageVersion: %s\n", yamlString(v))
+	}
+	footer := func(b *strings.Builder, manifestType string) {
+		fmt.Fprintf(b, "ManifestType: %s\n", manifestType)
+		fmt.Fprintf(b, "ManifestVersion: %s\n", wingetManifestVersion)
+	}
+
+	var installer strings.Builder
+	header(&installer, "installer")
+	fmt.Fprintf(&installer, "InstallerType: zip\n")
+	fmt.Fprintf(&installer, "ReleaseDate: %s\n", buildStart.Format("2006-01-02"))
+	fmt.Fprintf(&installer, "Installers:\n")
+	found := make(map[string]bool)
+	for _, a := range targetArtifacts(config, targetOutputName(target)) {
+		arch, ok := wingetArchs[a.GOARCH]
+		if a.GOOS != "windows" || !ok || found[arch] {
+			continue
+		}
+		found[arch] = true
+		url, err := releaseURL(ctx, config, version, a, a.Archive)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := releaseSHA256(config.BinDir, a.Archive)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&installer, "- Architecture: %s\n", arch)
+		fmt.Fprintf(&installer, "  NestedInstallerType: portable\n")
+		fmt.Fprintf(&installer, "  NestedInstallerFiles:\n")
+		fmt.Fprintf(&installer, "  - RelativeFilePath: %s\n", yamlString(strings.TrimSuffix(a.Archive, ".zip")+`\`+a.Binary))
+		fmt.Fprintf(&installer, "    PortableCommandAlias: %s\n", yamlString(name))
+		fmt.Fprintf(&installer, "  InstallerUrl: %s\n", yamlString(url))
+		fmt.Fprintf(&installer, "  InstallerSha256: %s\n", strings.ToUpper(sum))
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	footer(&installer, "installer")
+
+	var locale strings.Builder
+	header(&locale, "defaultLocale")
+	fmt.Fprintf(&locale, "PackageLocale: en-US\n")
+	fmt.Fprintf(&locale, "Publisher: %s\n", yamlString(wc.Publisher))
+	fmt.Fprintf(&locale, "PackageName: %s\n", yamlString(name))
+	if info.Homepage != "" {
+		fmt.Fprintf(&locale, "PackageUrl: %s\n", yamlString(info.Homepage))
+	}
+	fmt.Fprintf(&locale, "License: %s\n", yamlString(info.License))
+	fmt.Fprintf(&locale, "ShortDescription: %s\n", yamlString(info.Description))
+	footer(&locale, "defaultLocale")
+
+	var versionManifest strings.Builder
+	header(&versionManifest, "version")
+	fmt.Fprintf(&versionManifest, "DefaultLocale: en-US\n")
+	footer(&versionManifest, "version")
+
+	return []wingetManifest{
+		{id + ".yaml", versionManifest.String()},
+		{id + ".installer.yaml", installer.String()},
+		{id + ".locale.en-US.yaml", locale.String()},
+	}, nil
+}
+
+// YAML double quoted string, JSON escapes are valid YAML
+func yamlString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
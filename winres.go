@@ -0,0 +1,379 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Windows resources: icon, version info and application manifest of
+// a target compiled to a COFF .syso next to the main package, picked
+// up by go build for the windows GOARCH and removed after the build
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// WindowsResourcesConfig configures the resources of the windows
+// binaries of a target. The project level section is the default for all
+// targets, a target's section replaces it. The strings are templated.
+type WindowsResourcesConfig struct {
+	Icon        string `json:"icon"`         // .ico file, relative to the project directory
+	Company     string `json:"company"`      // CompanyName
+	Description string `json:"description"`  // FileDescription, default the output name of the target
+	Copyright   string `json:"copyright"`    // LegalCopyright
+	ProductName string `json:"product_name"` // default the project name
+	Manifest    string `json:"manifest"`     // application manifest file, relative to the project directory
+	Skip        bool   `json:"skip"`         // no resources for this target
+}
+
+// Resource types and the language of the resources (en-US, Unicode)
+const (
+	rtIcon         = 3
+	rtGroupIcon    = 14
+	rtVersion      = 16
+	rtManifest     = 24
+	resourceLang   = 0x0409
+	resourceLangCP = "040904b0"
+)
+
+// COFF machine and the relocation of the resource data addresses of the
+// windows GOARCH
+var winresMachines = map[string]struct {
+	machine uint16
+	reloc   uint16
+}{
+	"386":   {pe.IMAGE_FILE_MACHINE_I386, 0x0007},  // IMAGE_REL_I386_DIR32NB
+	"amd64": {pe.IMAGE_FILE_MACHINE_AMD64, 0x0003}, // IMAGE_REL_AMD64_ADDR32NB
+	"arm64": {pe.IMAGE_FILE_MACHINE_ARM64, 0x0002}, // IMAGE_REL_ARM64_ADDR32NB
+}
+
+// winResource is a resource of the .rsrc section
+type winResource struct {
+	typ, id uint16
+	data    []byte
+}
+
+// Write the .syso with the resources of a windows platform to the
+// directory of the package being built. Returns its path, empty if there
+// is nothing to embed. The caller removes it after the build.
+func writeWindowsResources(config *Config, version, buildPath, binaryName string, p platform, data templateData) (string, error) {
+	rc := config.WindowsResources
+	if p.GOOS != "windows" || rc == nil || rc.Skip {
+		return "", nil
+	}
+	m, ok := winresMachines[p.GOARCH]
+	if !ok {
+		fmt.Printf("Windows resources are not supported for %s, skipping\n", p.Label)
+		return "", nil
+	}
+	dir := buildPath
+	if dir == "" || strings.HasSuffix(dir, ".go") {
+		dir = filepath.Dir(dir)
+	}
+	syso := filepath.Join(dir, fmt.Sprintf("zz_xbuild_windows_%s.syso", p.GOARCH))
+	if config.DryRun {
+		fmt.Printf("Would embed Windows resources with %s\n", syso)
+		return "", nil
+	}
+
+	resources, err := windowsResources(config, version, binaryName, rc, data)
+	if err != nil {
+		return "", fmt.Errorf("windows resources: %v", err)
+	}
+	obj := winresCOFF(m.machine, m.reloc, resources)
+	others, _ := filepath.Glob(filepath.Join(dir, "*.syso"))
+	for _, other := range others {
+		if filepath.Base(other) != filepath.Base(syso) {
+			fmt.Printf("Warning: %s is linked too, its resources might clash\n", other)
+		}
+	}
+	if err := os.WriteFile(syso, obj, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", syso, err)
+	}
+	fmt.Printf("Embedding Windows resources with %s\n", syso)
+	return syso, nil
+}
+
+// The icon, version info and manifest resources
+func windowsResources(config *Config, version, binaryName string, rc *WindowsResourcesConfig, data templateData) ([]winResource, error) {
+	var resources []winResource
+	if rc.Icon != "" {
+		ico, err := os.ReadFile(rc.Icon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read icon: %v", err)
+		}
+		images, group, err := iconResources(ico)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rc.Icon, err)
+		}
+		for i, image := range images {
+			resources = append(resources, winResource{rtIcon, uint16(i + 1), image})
+		}
+		resources = append(resources, winResource{rtGroupIcon, 1, group})
+	}
+
+	productName := config.ProjectName
+	if config.ProjectConfig != nil && config.ProjectConfig.ProjectName != "" {
+		productName = config.ProjectConfig.ProjectName
+	}
+	strs := []struct{ key, value string }{
+		{"CompanyName", rc.Company},
+		{"FileDescription", rc.Description},
+		{"FileVersion", strings.TrimPrefix(version, "v")},
+		{"InternalName", config.ProjectName},
+		{"LegalCopyright", rc.Copyright},
+		{"OriginalFilename", binaryName},
+		{"ProductName", rc.ProductName},
+		{"ProductVersion", strings.TrimPrefix(version, "v")},
+	}
+	defaults := map[string]string{"FileDescription": config.ProjectName, "ProductName": productName}
+	var table [][]byte
+	for _, s := range strs {
+		value, err := expandTemplate(s.value, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s.key, err)
+		}
+		if value == "" {
+			value = defaults[s.key]
+		}
+		if value == "" {
+			continue
+		}
+		text := utf16z(value)
+		table = append(table, versionBlock(s.key, 1, text, len(text)/2))
+	}
+	resources = append(resources, winResource{rtVersion, 1, versionInfo(version, table)})
+
+	if rc.Manifest != "" {
+		manifest, err := os.ReadFile(rc.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %v", err)
+		}
+		resources = append(resources, winResource{rtManifest, 1, manifest})
+	}
+	return resources, nil
+}
+
+// Split an .ico file into the RT_ICON images and the RT_GROUP_ICON
+// directory referring to them by id 1..n
+func iconResources(ico []byte) ([][]byte, []byte, error) {
+	if len(ico) < 6 || binary.LittleEndian.Uint16(ico[0:]) != 0 || binary.LittleEndian.Uint16(ico[2:]) != 1 {
+		return nil, nil, fmt.Errorf("not an .ico file")
+	}
+	count := int(binary.LittleEndian.Uint16(ico[4:]))
+	if count == 0 || len(ico) < 6+16*count {
+		return nil, nil, fmt.Errorf("no icons")
+	}
+	var images [][]byte
+	group := bytes.NewBuffer(ico[:6:6])
+	for i := 0; i < count; i++ {
+		entry := ico[6+16*i : 6+16*(i+1)]
+		size := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(size) > uint64(len(ico)) {
+			return nil, nil, fmt.Errorf("icon %d is truncated", i+1)
+		}
+		images = append(images, ico[offset:offset+size])
+		// GRPICONDIRENTRY is ICONDIRENTRY with the id in place of the offset
+		group.Write(entry[:12])
+		binary.Write(group, binary.LittleEndian, uint16(i+1))
+	}
+	return images, group.Bytes(), nil
+}
+
+// VS_VERSIONINFO with the fixed file info and the strings of the table
+func versionInfo(version string, table [][]byte) []byte {
+	v := fileVersion(version)
+	ms := uint32(v[0])<<16 | uint32(v[1])
+	ls := uint32(v[2])<<16 | uint32(v[3])
+	var fixed bytes.Buffer
+	binary.Write(&fixed, binary.LittleEndian, []uint32{
+		0xfeef04bd, // signature
+		0x00010000, // structure version
+		ms,         // file version
+		ls,
+		ms, // product version
+		ls,
+		0x3f,    // file flags mask
+		0,       // file flags
+		0x40004, // VOS_NT_WINDOWS32
+		1,       // VFT_APP
+		0, 0, 0, // subtype, date
+	})
+	var translation bytes.Buffer
+	binary.Write(&translation, binary.LittleEndian, []uint16{resourceLang, 1200})
+
+	strs := versionBlock("StringFileInfo", 1, nil, 0, versionBlock(resourceLangCP, 1, nil, 0, table...))
+	vars := versionBlock("VarFileInfo", 1, nil, 0, versionBlock("Translation", 0, translation.Bytes(), translation.Len()))
+	return versionBlock("VS_VERSION_INFO", 0, fixed.Bytes(), fixed.Len(), strs, vars)
+}
+
+// A block of the version info: length, value length, type, key, value and
+// children, each 32-bit aligned. The value length is in words for text.
+func versionBlock(key string, typ uint16, value []byte, valueLength int, children ...[]byte) []byte {
+	b := make([]byte, 6, 64)
+	b = append(b, utf16z(key)...)
+	b = pad4(b)
+	b = append(b, value...)
+	for _, child := range children {
+		b = pad4(b)
+		b = append(b, child...)
+	}
+	binary.LittleEndian.PutUint16(b[0:], uint16(len(b)))
+	binary.LittleEndian.PutUint16(b[2:], uint16(valueLength))
+	binary.LittleEndian.PutUint16(b[4:], typ)
+	return b
+}
+
+// Major, minor, patch and build of a version, e.g. v1.2.3-rc1 is 1.2.3.0
+func fileVersion(version string) [4]uint16 {
+	var v [4]uint16
+	s := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	for i, part := range strings.SplitN(s, ".", 4) {
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil {
+			break
+		}
+		v[i] = uint16(n)
+	}
+	return v
+}
+
+// UTF-16LE string with the terminating zero
+func utf16z(s string) []byte {
+	units := append(utf16.Encode([]rune(s)), 0)
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+// Pad to a multiple of 4 bytes
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// COFF object with a .rsrc section: the type, id and language
+// directories, the data entries and the data. The addresses of the data
+// in the data entries are relocated against the section by the linker.
+func winresCOFF(machine, relocType uint16, resources []winResource) []byte {
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].typ != resources[j].typ {
+			return resources[i].typ < resources[j].typ
+		}
+		return resources[i].id < resources[j].id
+	})
+	var types []uint16
+	count := make(map[uint16]int)
+	for _, r := range resources {
+		if count[r.typ] == 0 {
+			types = append(types, r.typ)
+		}
+		count[r.typ]++
+	}
+
+	// offsets of the directories, data entries and data in the section
+	offset := 16 + 8*len(types)
+	typeDirs := make(map[uint16]int)
+	for _, t := range types {
+		typeDirs[t] = offset
+		offset += 16 + 8*count[t]
+	}
+	langDirs := make([]int, len(resources))
+	for i := range resources {
+		langDirs[i] = offset
+		offset += 16 + 8
+	}
+	dataEntries := make([]int, len(resources))
+	for i := range resources {
+		dataEntries[i] = offset
+		offset += 16
+	}
+	dataOffsets := make([]int, len(resources))
+	for i, r := range resources {
+		offset = (offset + 7) &^ 7
+		dataOffsets[i] = offset
+		offset += len(r.data)
+	}
+
+	var rsrc bytes.Buffer
+	le := func(values ...interface{}) {
+		for _, v := range values {
+			binary.Write(&rsrc, binary.LittleEndian, v)
+		}
+	}
+	directory := func(entries int) {
+		le(uint32(0), uint32(0), uint16(0), uint16(0), uint16(0), uint16(entries))
+	}
+	const subdirectory = 0x80000000
+	directory(len(types))
+	for _, t := range types {
+		le(uint32(t), uint32(subdirectory|typeDirs[t]))
+	}
+	for _, t := range types {
+		directory(count[t])
+		for i, r := range resources {
+			if r.typ == t {
+				le(uint32(r.id), uint32(subdirectory|langDirs[i]))
+			}
+		}
+	}
+	for i := range resources {
+		directory(1)
+		le(uint32(resourceLang), uint32(dataEntries[i]))
+	}
+	var relocs []pe.Reloc
+	for i, r := range resources {
+		relocs = append(relocs, pe.Reloc{VirtualAddress: uint32(rsrc.Len()), SymbolTableIndex: 0, Type: relocType})
+		le(uint32(dataOffsets[i]), uint32(len(r.data)), uint32(0), uint32(0))
+	}
+	for i, r := range resources {
+		rsrc.Write(make([]byte, dataOffsets[i]-rsrc.Len()))
+		rsrc.Write(r.data)
+	}
+	rsrc.Write(make([]byte, (4-rsrc.Len()%4)%4))
+
+	// file header, section header, section data, relocations, symbols
+	// and the empty string table
+	const headers = 20 + 40
+	relocOffset := headers + rsrc.Len()
+	symbolOffset := relocOffset + 10*len(relocs)
+	var obj bytes.Buffer
+	binary.Write(&obj, binary.LittleEndian, pe.FileHeader{
+		Machine:              machine,
+		NumberOfSections:     1,
+		PointerToSymbolTable: uint32(symbolOffset),
+		NumberOfSymbols:      1,
+	})
+	section := pe.SectionHeader32{
+		SizeOfRawData:        uint32(rsrc.Len()),
+		PointerToRawData:     headers,
+		PointerToRelocations: uint32(relocOffset),
+		NumberOfRelocations:  uint16(len(relocs)),
+		Characteristics:      pe.IMAGE_SCN_CNT_INITIALIZED_DATA | pe.IMAGE_SCN_MEM_READ,
+	}
+	copy(section.Name[:], ".rsrc")
+	binary.Write(&obj, binary.LittleEndian, section)
+	obj.Write(rsrc.Bytes())
+	for _, r := range relocs {
+		binary.Write(&obj, binary.LittleEndian, r)
+	}
+	symbol := pe.COFFSymbol{SectionNumber: 1, StorageClass: 3} // IMAGE_SYM_CLASS_STATIC
+	copy(symbol.Name[:], ".rsrc")
+	binary.Write(&obj, binary.LittleEndian, symbol)
+	binary.Write(&obj, binary.LittleEndian, uint32(4))
+	return obj.Bytes()
+}
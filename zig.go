@@ -0,0 +1,144 @@
+package main
+
+/////////////////////////////////////////////////////////////////////
+// Cross compile cgo with zig cc. With "cgo_toolchain": "zig" in
+// build-config.json, CC and CXX are set to zig cc/c++ with the target
+// triple mapped from GOOS/GOARCH and CGO_ENABLED=1
+/////////////////////////////////////////////////////////////////////
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ZigConfig configures the zig toolchain
+type ZigConfig struct {
+	Path         string `json:"path"`          // zig executable (optional, default: zig in PATH)
+	Libc         string `json:"libc"`          // linux libc: "gnu" (default) or "musl"
+	GlibcVersion string `json:"glibc_version"` // pin glibc for linux gnu builds, e.g. "2.17" (optional)
+}
+
+// zig architecture names for GOARCH
+var zigArchs = map[string]string{
+	"386":      "x86",
+	"amd64":    "x86_64",
+	"arm":      "arm",
+	"arm64":    "aarch64",
+	"loong64":  "loongarch64",
+	"ppc64le":  "powerpc64le",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64":   "mips64",
+	"mips64le": "mips64el",
+}
+
+// Validate cgo_toolchain and zig settings of a project or target
+func checkCgoToolchain(where, toolchain string, zig *ZigConfig) error {
+	switch toolchain {
+	case "", "none", "zig":
+	default:
+		return fmt.Errorf("%s: unknown cgo_toolchain %q (supported: zig, none)", where, toolchain)
+	}
+	if zig != nil {
+		switch zig.Libc {
+		case "", "gnu", "musl":
+		default:
+			return fmt.Errorf("%s: unknown zig libc %q (supported: gnu, musl)", where, zig.Libc)
+		}
+		if zig.GlibcVersion != "" && zig.Libc == "musl" {
+			return fmt.Errorf("%s: zig glibc_version can not be used with musl", where)
+		}
+	}
+	return nil
+}
+
+// Get the zig target triple for the platform, e.g. aarch64-linux-gnu.2.17.
+// gomips is GOMIPS of the build, softfloat selects the soft float ABI of
+// 32 bit mips.
+func zigTarget(p platform, zig *ZigConfig, gomips string) (string, error) {
+	arch, ok := zigArchs[p.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("zig: GOARCH %s is not supported", p.GOARCH)
+	}
+
+	switch p.GOOS {
+	case "linux":
+		abi := "gnu"
+		if zig.Libc == "musl" {
+			abi = "musl"
+		}
+		// zig only has libcs for the ABI variants of arm and mips
+		switch p.GOARCH {
+		case "arm":
+			if p.GOARM == "5" {
+				abi += "eabi"
+			} else {
+				abi += "eabihf"
+			}
+		case "mips", "mipsle":
+			if gomips == "softfloat" {
+				abi += "eabi"
+			} else {
+				abi += "eabihf"
+			}
+		case "mips64", "mips64le":
+			abi += "abi64"
+		}
+		target := arch + "-linux-" + abi
+		if zig.GlibcVersion != "" {
+			target += "." + zig.GlibcVersion
+		}
+		return target, nil
+	case "darwin":
+		if p.GOARCH != "amd64" && p.GOARCH != "arm64" {
+			return "", fmt.Errorf("zig: darwin/%s is not supported", p.GOARCH)
+		}
+		return arch + "-macos", nil
+	case "windows":
+		if p.GOARCH != "386" && p.GOARCH != "amd64" && p.GOARCH != "arm64" {
+			return "", fmt.Errorf("zig: windows/%s is not supported", p.GOARCH)
+		}
+		return arch + "-windows-gnu", nil
+	}
+	return "", fmt.Errorf("zig: GOOS %s is not supported", p.GOOS)
+}
+
+// Get the environment for building the platform with cgo using zig,
+// gomips as for zigTarget
+func zigEnv(p platform, zig *ZigConfig, gomips string) (map[string]string, error) {
+	if zig == nil {
+		zig = &ZigConfig{}
+	}
+
+	zigPath := zig.Path
+	if zigPath == "" {
+		zigPath = "zig"
+	}
+	zigPath, err := exec.LookPath(zigPath)
+	if err != nil {
+		return nil, fmt.Errorf("cgo_toolchain is zig but zig is not installed: %v", err)
+	}
+	if strings.ContainsAny(zigPath, " \t") {
+		zigPath = strconv.Quote(zigPath)
+	}
+
+	target, err := zigTarget(p, zig, gomips)
+	if err != nil {
+		return nil, err
+	}
+	flags := " -target " + target
+	// Raspberry Pi Jessie (GOARM=6) is ARMv6 with hard float
+	if p.GOARM == "6" {
+		flags += " -mcpu=arm1176jzf_s"
+	}
+
+	return map[string]string{
+		"CGO_ENABLED": "1",
+		"CC":          zigPath + " cc" + flags,
+		"CXX":         zigPath + " c++" + flags,
+	}, nil
+}